@@ -0,0 +1,202 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// archiveTime is used as modification time for all archive entries,
+// to make archives reproducible. Zip files cannot represent earlier dates.
+var archiveTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func (b *bygge) handleTar(target string, cmd string, args ...string) error {
+	files, err := archiveSources(target, strings.TrimPrefix(cmd, "tar:"), args)
+	if err != nil {
+		return err
+	}
+	compress := strings.HasSuffix(target, "gz")
+
+	return b.createArchive(target, func(output io.Writer) error {
+		var gzipWriter *gzip.Writer
+		if compress {
+			gzipWriter = gzip.NewWriter(output)
+			output = gzipWriter
+		}
+		tarWriter := tar.NewWriter(output)
+
+		for _, file := range files {
+			info, err := os.Stat(filepath.FromSlash(file))
+			if err != nil {
+				return err
+			}
+			hdr := &tar.Header{
+				Name:    file,
+				Mode:    int64(archiveMode(info).Perm()),
+				ModTime: archiveTime,
+			}
+			if info.IsDir() {
+				hdr.Typeflag = tar.TypeDir
+				hdr.Name += "/"
+			} else {
+				hdr.Typeflag = tar.TypeReg
+				hdr.Size = info.Size()
+			}
+			if err = tarWriter.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				if err = copyFileTo(tarWriter, filepath.FromSlash(file)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := tarWriter.Close(); err != nil {
+			return err
+		}
+		if gzipWriter != nil {
+			return gzipWriter.Close()
+		}
+		return nil
+	})
+}
+
+func (b *bygge) handleZip(target string, cmd string, args ...string) error {
+	files, err := archiveSources(target, strings.TrimPrefix(cmd, "zip:"), args)
+	if err != nil {
+		return err
+	}
+
+	return b.createArchive(target, func(output io.Writer) error {
+		zipWriter := zip.NewWriter(output)
+
+		for _, file := range files {
+			info, err := os.Stat(filepath.FromSlash(file))
+			if err != nil {
+				return err
+			}
+			hdr := &zip.FileHeader{
+				Name:     file,
+				Method:   zip.Deflate,
+				Modified: archiveTime,
+			}
+			hdr.SetMode(archiveMode(info))
+			if info.IsDir() {
+				hdr.Name += "/"
+				hdr.Method = zip.Store
+			}
+			entry, err := zipWriter.CreateHeader(hdr)
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				if err = copyFileTo(entry, filepath.FromSlash(file)); err != nil {
+					return err
+				}
+			}
+		}
+		return zipWriter.Close()
+	})
+}
+
+// createArchive writes an archive to a temporary file that replaces
+// the target when complete.
+func (b *bygge) createArchive(target string, write func(io.Writer) error) error {
+	b.verbose("Creating archive %s", target)
+	tmpFile, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+	}()
+	if err = write(tmpFile); err != nil {
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), target)
+}
+
+// archiveSources expands the given files, directories and glob patterns
+// into a sorted list of all files and directories to archive. The paths
+// are slash separated, so that entries are ordered the same on all platforms.
+func archiveSources(target string, first string, args []string) ([]string, error) {
+	patterns := args
+	first = strings.TrimSpace(first)
+	if len(first) > 0 {
+		patterns = append([]string{first}, args...)
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("Nothing to archive")
+	}
+
+	matches, err := expandGlobs(patterns...)
+	if err != nil {
+		return nil, err
+	}
+
+	found := map[string]bool{}
+	for _, match := range matches {
+		// Entries must not escape the extraction directory
+		clean := filepath.Clean(match)
+		if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("Will not archive %q, it is not inside the base dir", match)
+		}
+		err := filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if path == "." {
+				return nil
+			}
+			if !info.IsDir() && !info.Mode().IsRegular() {
+				return fmt.Errorf("unsupported file type: %v", info.Mode().String())
+			}
+			found[filepath.Clean(path)] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	delete(found, filepath.Clean(target))
+
+	files := []string{}
+	for file := range found {
+		files = append(files, filepath.ToSlash(file))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// archiveMode normalizes file permissions for reproducible archives.
+func archiveMode(info os.FileInfo) os.FileMode {
+	if info.IsDir() {
+		return os.ModeDir | 0755
+	}
+	if info.Mode()&0111 != 0 {
+		return 0755
+	}
+	return 0644
+}
+
+func copyFileTo(writer io.Writer, file string) error {
+	source, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	_, err = io.Copy(writer, source)
+	return err
+}
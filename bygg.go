@@ -19,8 +19,13 @@ func main() {
 		os.Exit(1)
 	}
 
-	b.verbose("Building target %q", cfg.target)
-	err = b.buildTarget(cfg.target)
+	if cfg.clean {
+		b.verbose("Cleaning target %q", cfg.target)
+		err = b.cleanTarget(cfg.target)
+	} else {
+		b.verbose("Building target %q", cfg.target)
+		err = b.buildTarget(cfg.target)
+	}
 	if err != nil {
 		fmt.Printf("%v\n", err)
 		os.Exit(1)
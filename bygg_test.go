@@ -1,11 +1,17 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
@@ -111,6 +117,186 @@ func TestVariables_C(t *testing.T) {
 	)
 }
 
+func TestTargetVariables(t *testing.T) {
+	verifyTestOutput(
+		t, "scoped.bygg", "app",
+		"lib app -O2 -g [debug]\napp app [debug]\n",
+	)
+	verifyTestOutput(
+		t, "scoped.bygg", "other",
+		"lib global -O2 -g []\n",
+	)
+}
+
+func TestConditionals(t *testing.T) {
+	verifyTestOutput(
+		t, "conditionals.bygg", "A",
+		"flags: -g\nexists\n",
+	)
+	verifyTestOutput(
+		t, "conditionals.bygg", "B",
+		"not defined\n",
+	)
+	verifyTestOutput(
+		t, "conditionals.bygg", "C",
+		"later\n",
+	)
+	verifyBuildFails(t, "badconditional.bygg", "A")
+}
+
+func TestExpansion(t *testing.T) {
+	verifyTestOutput(
+		t, "expansion.bygg", "A",
+		"build fallback build\n",
+	)
+	verifyTestOutput(
+		t, "expansion.bygg", "C",
+		"[]\n",
+	)
+
+	b, err := loadTestBuild("expansion.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.buildTarget("B")
+	if err == nil || !strings.Contains(err.Error(), "MISSING must be set") {
+		t.Fatalf("Expected unset variable error, got: %v", err)
+	}
+
+	b, err = loadTestBuild("expansion.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.cfg.strict = true
+	err = b.buildTarget("C")
+	if err == nil || !strings.Contains(err.Error(), `undefined variable "MISSING"`) {
+		t.Fatalf("Expected undefined variable error, got: %v", err)
+	}
+}
+
+func TestDeferredVariables(t *testing.T) {
+	verifyTestOutput(
+		t, "deferred.bygg", "A",
+		"hello, world\n",
+	)
+	verifyTestOutput(
+		t, "deferred.bygg", "B",
+		"hello, world!\n",
+	)
+	verifyTestOutput(
+		t, "deferred.bygg", "C",
+		"a b\n",
+	)
+	verifyTestOutput(
+		t, "deferred.bygg", "F",
+		"hello, world?\n",
+	)
+	verifyTestOutput(
+		t, "deferred.bygg", "G",
+		"[-O2 -g]\n",
+	)
+	verifyBuildFails(t, "deferred.bygg", "D")
+	verifyBuildFails(t, "deferred.bygg", "E")
+}
+
+func TestFunctions(t *testing.T) {
+	expected := map[string]string{
+		"subst":    "src/main.o src/util.o lib/extra.o README.md src/util.o",
+		"patsubst": "build/main.o build/util.o lib/extra.c build/util.o",
+		"basename": "src/main src/util lib/extra README src/util",
+		"dir":      "./ src/ src/ lib/ ./ src/",
+		"notdir":   "main.c util.c extra.c README.md util.c",
+		"prefix":   "build/main.c build/util.c build/util.c",
+		"suffix":   "a.bak b.bak",
+		"wildcard": "assets/a.txt assets/sub/b.txt",
+		"sort":     "README.md lib/extra.c src/main.c src/util.c",
+		"nested":   "a b",
+		"late":     "x.o y.o",
+		"unknown":  "$(nosuchfunction a)",
+	}
+	for target, output := range expected {
+		verifyTestOutput(t, "functions.bygg", target, output+"\n")
+	}
+}
+
+func TestFunctions_shellSubstitution(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	verifyTestOutput(t, "functions.bygg", "shell", "substituted file\n")
+}
+
+func TestMultiOutput(t *testing.T) {
+	os.RemoveAll("tests/gen")
+	defer os.RemoveAll("tests/gen")
+
+	verifyTestOutput(t, "multi.bygg", "all", "generating\n")
+	verifyTestOutput(t, "multi.bygg", "all", "")
+	verifyTestOutput(t, "multi.bygg", "header", "")
+
+	if err := os.Remove("tests/gen/parser.h"); err != nil {
+		t.Fatal(err)
+	}
+	verifyTestOutput(t, "multi.bygg", "header", "generating\n")
+	if !exists("tests/gen/parser.c") || !exists("tests/gen/parser.h") {
+		t.Fatal("Expected all outputs to be generated")
+	}
+}
+
+func TestOrderOnlyDependency(t *testing.T) {
+	os.RemoveAll("tests/out")
+	defer os.RemoveAll("tests/out")
+
+	verifyTestOutput(t, "orderonly.bygg", "out/file.txt", "creating dir\nbuilding file\n")
+	verifyTestOutput(t, "orderonly.bygg", "out/file.txt", "")
+
+	// Touching the directory should not cause a rebuild
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes("tests/out", later, later); err != nil {
+		t.Fatal(err)
+	}
+	verifyTestOutput(t, "orderonly.bygg", "out/file.txt", "")
+}
+
+func TestPhony(t *testing.T) {
+	// Phony targets are built even if there is an up to date file with the same name
+	if err := ioutil.WriteFile("tests/test", []byte{}, 0666); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("tests/test")
+	verifyTestOutput(t, "phony.bygg", "test", "testing\n")
+	verifyTestOutput(t, "phony.bygg", "test", "testing\n")
+
+	verifyTestOutput(t, "phony.bygg", "missing",
+		"I am a child\nWarning: build commands for \"missing\" did not produce \"missing\", declare phony targets using \".phony\"\n",
+	)
+
+	// Declaring a secondary output as phony applies to the whole multi-output target
+	verifyTestOutput(t, "phony.bygg", "gen2", "I am a child\n")
+	verifyTestOutput(t, "phony.bygg", "gen1", "I am a child\n")
+	verifyTestOutput(t, "phony.bygg", "gen3", "I am a child\n")
+
+	b, err := loadTestBuild("phony.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.cfg.strict = true
+	if err = b.buildTarget("missing"); err == nil {
+		t.Fatal("Expected missing output to fail in strict mode")
+	}
+}
+
+func TestMultiOutput_empty(t *testing.T) {
+	b, err := loadTestBuild("multiempty.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.buildTarget("all")
+	if err == nil || !strings.Contains(err.Error(), "no outputs, in line 3") {
+		t.Fatalf("Expected parse error, got: %v", err)
+	}
+}
+
 func TestEnvironmentVariable(t *testing.T) {
 	os.Setenv("HOME", "Home")
 	home := os.Getenv("HOME")
@@ -135,6 +321,60 @@ func TestBuiltinEnvironmentVariable(t *testing.T) {
 	)
 }
 
+func TestInclude(t *testing.T) {
+	verifyTestOutput(t, "include.bygg", "main", "Hello from main\n")
+	verifyTestOutput(t, "include.bygg", "shared", "Shared\n")
+	verifyTestOutput(t, "include.bygg", "moduleB", "Hello from B\n")
+
+	if _, err := loadTestBuild("include/cycle.bygg"); err == nil {
+		t.Error("cyclic include not detected")
+	}
+}
+
+func TestImport(t *testing.T) {
+	defer os.Remove("tests/sub/out")
+
+	verifyTestOutput(t, "import.bygg", "all", "Done\n")
+	content, err := os.ReadFile("tests/sub/out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "Hello from sub\n" {
+		t.Errorf("Unexpected content: %q", content)
+	}
+
+	b, err := loadTestBuild("import.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pwd, _ := os.Getwd()
+	os.Chdir("tests")
+	defer os.Chdir(pwd)
+	if err = b.loadScript(); err != nil {
+		t.Fatal(err)
+	}
+	imported, ok := b.targets["lib/out"]
+	if !ok {
+		t.Fatal("imported target not found")
+	}
+	if fmt.Sprint(imported.dependencies) != "[sub/input.txt]" {
+		t.Errorf("Unexpected dependencies: %v", imported.dependencies)
+	}
+}
+
+func TestImport_cycle(t *testing.T) {
+	for _, file := range []string{"importself.bygg", "importa.bygg"} {
+		b, err := loadTestBuild(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = b.buildTarget("all")
+		if err == nil || !strings.Contains(err.Error(), "cyclic import") {
+			t.Errorf("Expected cyclic import error for %q, got: %v", file, err)
+		}
+	}
+}
+
 func TestDependencyChain_A(t *testing.T) {
 	verifyTestOutput(
 		t, "dependencies.bygg", "A",
@@ -163,6 +403,217 @@ func TestFileDependency(t *testing.T) {
 	)
 }
 
+func TestTreeDependency(t *testing.T) {
+	now := time.Now()
+	os.WriteFile("tests/tree.out", []byte{}, 0644)
+	defer os.Remove("tests/tree.out")
+
+	os.Chtimes("tests/tree.out", now, now)
+	earlier := now.Add(-time.Hour)
+	err := filepath.Walk("tests/assets", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		modified := info.ModTime()
+		t.Cleanup(func() {
+			os.Chtimes(path, modified, modified)
+		})
+		return os.Chtimes(path, earlier, earlier)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifyTestOutput(t, "dependencies.bygg", "tree.out", "")
+
+	later := now.Add(time.Hour)
+	os.Chtimes("tests/assets/sub/b.txt", later, later)
+	verifyTestOutput(t, "dependencies.bygg", "tree.out", "Tree changed\n")
+}
+
+func TestWaitForChange(t *testing.T) {
+	b, err := loadTestBuild("dependencies.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pwd, _ := os.Getwd()
+	os.Chdir("tests")
+	defer os.Chdir(pwd)
+	defer os.Remove("watched.txt")
+
+	os.WriteFile("watched.txt", []byte{}, 0644)
+	if err = b.loadScript(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		later := time.Now().Add(time.Second)
+		os.Chtimes("watched.txt", later, later)
+	}()
+
+	done := make(chan error)
+	go func() {
+		changed, err := b.waitForChange(b.targets["watched"])
+		if err == nil && fmt.Sprint(changed) != "[watched.txt]" {
+			err = fmt.Errorf("unexpected changes: %v", changed)
+		}
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("change not detected")
+	}
+}
+
+func TestWaitForChange_missingDir(t *testing.T) {
+	b, err := loadTestBuild("dependencies.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pwd, _ := os.Getwd()
+	os.Chdir("tests")
+	defer os.Chdir(pwd)
+	defer os.RemoveAll("later")
+
+	if err = b.loadScript(); err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.MkdirAll("later/nested", 0755)
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile("later/nested/input.txt", []byte{}, 0644)
+	}()
+
+	done := make(chan error)
+	go func() {
+		changed, err := b.waitForChange(b.targets["pending"])
+		if err == nil && fmt.Sprint(changed) != "[later/nested/input.txt]" {
+			err = fmt.Errorf("unexpected changes: %v", changed)
+		}
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("change not detected")
+	}
+}
+
+func TestWatchReload(t *testing.T) {
+	os.WriteFile("tests/reload.bygg", []byte("A << first\n"), 0644)
+	defer os.Remove("tests/reload.bygg")
+
+	b, err := loadTestBuild("reload.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.cfg.quietPeriod = 50 * time.Millisecond
+	pwd, _ := os.Getwd()
+	os.Chdir("tests")
+	defer os.Chdir(pwd)
+
+	if err = b.loadScript(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.WriteFile("reload.bygg", []byte("A << second\n"), 0644)
+		later := time.Now().Add(time.Second)
+		os.Chtimes("reload.bygg", later, later)
+	}()
+
+	if _, err = b.waitForChange(b.targets["A"]); err != nil {
+		t.Fatal(err)
+	}
+	if !b.scriptChangedSince(start) {
+		t.Fatal("script change not detected")
+	}
+	if err = b.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if commands := b.targets["A"].buildCommands; len(commands) != 1 || commands[0].command != "<< second" {
+		t.Errorf("Unexpected build commands after reload: %v", commands)
+	}
+
+	// Failing reloads keep the previous build graph
+	os.WriteFile("reload.bygg", []byte("A << third\nbroken line\n"), 0644)
+	if err = b.reload(); err == nil {
+		t.Fatal("Expected reload to fail")
+	}
+	if commands := b.targets["A"].buildCommands; len(commands) != 1 || commands[0].command != "<< second" {
+		t.Errorf("Unexpected build commands after failed reload: %v", commands)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no sleep command")
+	}
+	b, err := loadTestBuild("run.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.buildTarget("ready"); err != nil {
+		t.Fatal(err)
+	}
+
+	b.cfg.run = "missing"
+	if err = b.restartRunCommand(); err == nil {
+		t.Error("expected unknown run target to fail")
+	}
+	b.cfg.run = "broken"
+	if err = b.restartRunCommand(); err == nil {
+		t.Error("expected run target with several commands to fail")
+	}
+
+	// Dependencies of the run target are built with the build target,
+	// and left unresolved by restarting
+	b.cfg.run = "server"
+	b.unresolve()
+	capture.Reset()
+	if err = b.resolveRunTarget(); err != nil {
+		t.Fatal(err)
+	}
+	if capture.String() != "ready\n" {
+		t.Errorf("Expected run target dependencies to be built, got: %q", capture.String())
+	}
+	b.unresolve()
+	if err = b.restartRunCommand(); err != nil {
+		t.Fatal(err)
+	}
+	if b.targets["ready"].resolved {
+		t.Error("restarting should not resolve dependencies")
+	}
+	first := b.running
+	if err = b.restartRunCommand(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-first.done:
+	default:
+		t.Error("command not stopped on restart")
+	}
+
+	second := b.running
+	b.stopRunCommand()
+	select {
+	case <-second.done:
+	default:
+		t.Error("command not stopped")
+	}
+}
+
 func TestBuildCommand(t *testing.T) {
 	output := runTestBuild(t, "buildcommands.bygg", "help")
 	if !strings.Contains(output, "SWIG") {
@@ -190,6 +641,45 @@ func TestBuildCommand_Download(t *testing.T) {
 	}
 }
 
+func TestBuildCommand_Unpack(t *testing.T) {
+	defer func() {
+		os.RemoveAll("tests/download/unpacked")
+		os.RemoveAll("tests/download/stripped")
+		os.RemoveAll("tests/download/badsum")
+	}()
+
+	runTestBuild(t, "buildcommands.bygg", "download/unpacked")
+	if !exists("tests/download/unpacked/doubt.txt") {
+		t.Error("archive not unpacked")
+	}
+
+	runTestBuild(t, "buildcommands.bygg", "download/stripped")
+	if !exists("tests/download/stripped/doubt.txt") {
+		t.Error("archive not unpacked with stripped paths")
+	}
+
+	verifyBuildFails(t, "buildcommands.bygg", "download/badsum")
+}
+
+func TestUnpackArchive_escape(t *testing.T) {
+	for _, name := range []string{"../escaped.txt", "a/../../escaped.txt", ".."} {
+		var archive bytes.Buffer
+		writer := tar.NewWriter(&archive)
+		if err := writer.WriteHeader(&tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		writer.Close()
+
+		target := filepath.Join(t.TempDir(), "unpacked")
+		if err := unpackArchive(target, &archive, 0); err == nil {
+			t.Errorf("Expected %q to be rejected", name)
+		}
+		if exists(filepath.Join(filepath.Dir(target), "escaped.txt")) {
+			t.Errorf("%q unpacked outside of target", name)
+		}
+	}
+}
+
 func TestChildBuild(t *testing.T) {
 	verifyTestOutput(
 		t, "buildcommands.bygg", "child",
@@ -247,6 +737,34 @@ func TestTemplates_glob(t *testing.T) {
 	)
 }
 
+func TestTemplates_globignore(t *testing.T) {
+	os.WriteFile("tests/templates.ignored", []byte{}, 0644)
+	defer os.Remove("tests/templates.ignored")
+	verifyTestOutput(
+		t, "templates.bygg", "globignore",
+		"templates.bygg\n",
+	)
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	patterns := ignorePatterns{"*.swp", "build/", "docs/gen*"}
+	for file, expected := range map[string]bool{
+		"main.go":          false,
+		".main.go.swp":     true,
+		"src/.main.go.swp": true,
+		"build":            true,
+		"src/build/x.o":    true,
+		"docs/generated":   true,
+		"docs/gen/x.html":  true,
+		"src/docs/gen":     false,
+		"docs/index.html":  false,
+	} {
+		if patterns.matches(file) != expected {
+			t.Errorf("Expected %v for %q", expected, file)
+		}
+	}
+}
+
 func TestTemplates_replaceOne(t *testing.T) {
 	expected := "kawonka\n"
 	verifyTestOutput(
@@ -286,6 +804,114 @@ func Test_clean(t *testing.T) {
 	}
 }
 
+func Test_cleanGlob(t *testing.T) {
+	os.MkdirAll("tests/download/a", 0771)
+	os.WriteFile("tests/download/x.tmp", []byte{}, 0644)
+	os.WriteFile("tests/download/y.tmp", []byte{}, 0644)
+	defer func() {
+		os.Remove("tests/download/x.tmp")
+		os.Remove("tests/download/y.tmp")
+		os.RemoveAll("tests/download/a")
+	}()
+
+	b, err := loadTestBuild("buildcommands.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.cfg.dryRun = true
+	if err = b.buildTarget("cleanGlob"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Would remove \"download/x.tmp\"\nWould remove \"download/y.tmp\"\nWould remove \"download/a\"\n"
+	if capture.String() != expected {
+		t.Errorf("Expected: %q, got: %q", expected, capture.String())
+	}
+	if !exists("tests/download/x.tmp") {
+		t.Error("dry run removed files")
+	}
+
+	runTestBuild(t, "buildcommands.bygg", "cleanGlob")
+	if exists("tests/download/x.tmp") || exists("tests/download/y.tmp") || exists("tests/download/a") {
+		t.Error("files not removed")
+	}
+
+	verifyBuildFails(t, "buildcommands.bygg", "cleanOutside")
+	verifyBuildFails(t, "buildcommands.bygg", "cleanRoot")
+	if !exists("tests") {
+		t.Fatal("removed files outside of base dir")
+	}
+
+	// Links in the parent directories are resolved before checking
+	outside := t.TempDir()
+	if err = os.Mkdir(filepath.Join(outside, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink(outside, "tests/download/link"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove("tests/download/link")
+	verifyBuildFails(t, "buildcommands.bygg", "cleanLink")
+	if !exists(filepath.Join(outside, "sub")) {
+		t.Fatal("removed files outside of base dir through link")
+	}
+}
+
+func Test_cleanTarget(t *testing.T) {
+	defer os.RemoveAll("tests/cleantest")
+	defer os.RemoveAll("tests/cleansrc")
+	runTestBuild(t, "clean.bygg", "cleantest/app")
+	if !exists("tests/cleantest/app") {
+		t.Fatal("target not built")
+	}
+	if err := ioutil.WriteFile("tests/cleantest/generated", []byte{}, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll("tests/cleansrc", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := loadTestBuild("clean.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.cfg.dryRun = true
+	if err = b.cleanTarget("all"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "Would remove \"cleantest\"\nWould remove \"cleantest/app\"\nWould remove \"cleantest/generated\"\n"
+	if capture.String() != expected {
+		t.Errorf("Expected: %q, got: %q", expected, capture.String())
+	}
+
+	// Directories are only removed after confirmation
+	b, err = loadTestBuild("clean.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.input = bufio.NewReader(strings.NewReader("n\n"))
+	if err = b.cleanTarget("all"); err != nil {
+		t.Fatal(err)
+	}
+	if !exists("tests/cleantest") || exists("tests/cleantest/app") || exists("tests/cleantest/generated") {
+		t.Error("expected only files to be removed")
+	}
+
+	b, err = loadTestBuild("clean.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.input = bufio.NewReader(strings.NewReader("y\n"))
+	if err = b.cleanTarget("all"); err != nil {
+		t.Fatal(err)
+	}
+	if exists("tests/cleantest") {
+		t.Error("outputs not removed")
+	}
+	if !exists("tests/clean.bygg") || !exists("tests/cleansrc") {
+		t.Error("source removed")
+	}
+}
+
 func Test_copy(t *testing.T) {
 	defer func() {
 		os.Remove("tests/download/copytestA")
@@ -307,6 +933,346 @@ func Test_copy(t *testing.T) {
 	}
 }
 
+func Test_git(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	tmp := t.TempDir()
+	t.Setenv("HOME", tmp)
+	repo := filepath.Join(tmp, "repo.git")
+	work := filepath.Join(tmp, "work")
+
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{
+			"-c", "user.name=bygg", "-c", "user.email=bygg@example.com",
+		}, args...)...)
+		output, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git %v: %v", args, err)
+		}
+		return strings.TrimSpace(string(output))
+	}
+
+	git("init", "-q", "--bare", repo)
+	git("init", "-q", work)
+	if err := os.WriteFile(filepath.Join(work, "hello.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("-C", work, "add", "hello.txt")
+	git("-C", work, "commit", "-q", "-m", "hello")
+	git("-C", work, "tag", "v1")
+	git("-C", work, "push", "-q", repo, "HEAD:refs/heads/main", "v1")
+	commit := git("-C", work, "rev-parse", "HEAD")
+
+	t.Setenv("BYGG_TEST_REPO", repo)
+	t.Setenv("BYGG_TEST_REF", "v1")
+	t.Setenv("BYGG_TEST_COMMIT", commit)
+	defer func() {
+		os.RemoveAll("tests/download/gitrepo")
+		os.RemoveAll("tests/download/gitmismatch")
+	}()
+
+	verifyContent := func(expected string) {
+		t.Helper()
+		content, err := os.ReadFile("tests/download/gitrepo/hello.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Errorf("Unexpected content: %q", content)
+		}
+	}
+
+	runTestBuild(t, "buildcommands.bygg", "download/gitrepo")
+	verifyContent("hello")
+	runTestBuild(t, "buildcommands.bygg", "download/gitrepo")
+
+	// Changing the ref checks out the new ref in the existing clone
+	if err := os.WriteFile(filepath.Join(work, "hello.txt"), []byte("hello again"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git("-C", work, "commit", "-q", "-a", "-m", "again")
+	git("-C", work, "tag", "v2")
+	git("-C", work, "push", "-q", repo, "HEAD:refs/heads/main", "v2")
+	t.Setenv("BYGG_TEST_REF", "v2")
+	t.Setenv("BYGG_TEST_COMMIT", git("-C", work, "rev-parse", "HEAD"))
+
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes("tests/download/gitrepo", old, old); err != nil {
+		t.Fatal(err)
+	}
+	runTestBuild(t, "buildcommands.bygg", "download/gitrepo")
+	verifyContent("hello again")
+	stat, err := os.Stat("tests/download/gitrepo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stat.ModTime().After(old) {
+		t.Error("Checkout did not update the target modification time")
+	}
+
+	// A mismatching commit is detected before checking out
+	t.Setenv("BYGG_TEST_REF", "v1")
+	t.Setenv("BYGG_TEST_COMMIT", "0000000")
+	verifyBuildFails(t, "buildcommands.bygg", "download/gitrepo")
+	verifyContent("hello again")
+
+	verifyBuildFails(t, "buildcommands.bygg", "download/gitmismatch")
+}
+
+func Test_copyRecursive(t *testing.T) {
+	defer func() {
+		os.RemoveAll("tests/download/copydir")
+		os.RemoveAll("tests/download/copyglob")
+	}()
+
+	verifyBuildFails(t, "buildcommands.bygg", "download/copyfaildir")
+
+	runTestBuild(t, "buildcommands.bygg", "download/copydir")
+	if !exists("tests/download/copydir/a.txt") || !exists("tests/download/copydir/sub/b.txt") {
+		t.Error("directory not copied")
+	}
+
+	runTestBuild(t, "buildcommands.bygg", "download/copyglob")
+	if !exists("tests/download/copyglob/a.txt") || !exists("tests/download/copyglob/child.bygg") {
+		t.Error("glob not copied")
+	}
+
+	// Newer targets are not overwritten
+	newer := time.Now().Add(time.Hour)
+	os.WriteFile("tests/download/copyglob/a.txt", []byte("changed"), 0644)
+	os.Chtimes("tests/download/copyglob/a.txt", newer, newer)
+	os.Remove("tests/download/copyglob/child.bygg")
+	runTestBuild(t, "buildcommands.bygg", "download/copyglob")
+	content, _ := os.ReadFile("tests/download/copyglob/a.txt")
+	if string(content) != "changed" {
+		t.Error("newer file was overwritten")
+	}
+	if !exists("tests/download/copyglob/child.bygg") {
+		t.Error("missing file not copied")
+	}
+}
+
+func Test_copyPreserve(t *testing.T) {
+	source := "tests/download/copysource"
+	target := "tests/download/copypreserve"
+	defer func() {
+		os.Remove(source)
+		os.Remove(target)
+	}()
+
+	os.MkdirAll("tests/download", 0771)
+	if err := os.WriteFile(source, []byte("#!/bin/sh\n"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(source, 0750)
+	modified := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(source, modified, modified)
+
+	runTestBuild(t, "buildcommands.bygg", "download/copypreserve")
+	stat, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && stat.Mode().Perm() != 0750 {
+		t.Errorf("Expected mode %v, got %v", os.FileMode(0750), stat.Mode().Perm())
+	}
+	if !stat.ModTime().Equal(modified) {
+		t.Errorf("Expected modification time %v, got %v", modified, stat.ModTime())
+	}
+}
+
+func Test_moveOverwrite(t *testing.T) {
+	b, err := loadTestBuild("buildcommands.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The source may be on another file system, causing a copy
+	source := filepath.Join(t.TempDir(), "source.txt")
+	target := "tests/download/moveoverwrite"
+	os.MkdirAll("tests/download", 0771)
+	defer os.Remove(target)
+
+	if err = os.WriteFile(source, []byte("NEW"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(target, []byte("OLD"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	earlier := time.Now().Add(-time.Hour)
+	os.Chtimes(source, earlier, earlier)
+
+	if err = b.handleMove(target, "move:"+source); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "NEW" {
+		t.Errorf("Expected target to be overwritten, got %q", content)
+	}
+	if exists(source) {
+		t.Error("source not removed")
+	}
+}
+
+func Test_copyForce(t *testing.T) {
+	b, err := loadTestBuild("buildcommands.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	source := filepath.Join(dir, "source.txt")
+	target := filepath.Join(dir, "target.txt")
+	os.WriteFile(source, []byte("NEW"), 0644)
+	os.WriteFile(target, []byte("OLD"), 0644)
+	earlier := time.Now().Add(-time.Hour)
+	os.Chtimes(source, earlier, earlier)
+	stat, err := os.Stat(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = b.copyFile(source, target, stat, copyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(target); string(content) != "OLD" {
+		t.Errorf("Expected up to date target to be kept, got %q", content)
+	}
+	if err = b.copyFile(source, target, stat, copyOptions{force: true}); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(target); string(content) != "NEW" {
+		t.Errorf("Expected forced copy to overwrite, got %q", content)
+	}
+}
+
+func Test_fileCommands(t *testing.T) {
+	defer func() {
+		for _, file := range []string{"written", "touched", "linked", "movesource", "moved"} {
+			os.Remove("tests/download/" + file)
+		}
+	}()
+	os.MkdirAll("tests/download", 0771)
+
+	runTestBuild(t, "buildcommands.bygg", "download/written")
+	content, err := os.ReadFile("tests/download/written")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "Hello,\nworld!\n" {
+		t.Errorf("Unexpected content: %q", content)
+	}
+
+	runTestBuild(t, "buildcommands.bygg", "download/touched")
+	if !exists("tests/download/touched") {
+		t.Error("file not touched")
+	}
+
+	if runtime.GOOS != "windows" {
+		runTestBuild(t, "buildcommands.bygg", "download/linked")
+		content, err = os.ReadFile("tests/download/linked")
+		if err != nil || string(content) != "Hello,\nworld!\n" {
+			t.Errorf("Unexpected link content: %q, %v", content, err)
+		}
+	}
+
+	os.WriteFile("tests/download/movesource", []byte("moving"), 0644)
+	runTestBuild(t, "buildcommands.bygg", "download/moved")
+	if exists("tests/download/movesource") || !exists("tests/download/moved") {
+		t.Error("file not moved")
+	}
+}
+
+func Test_archives(t *testing.T) {
+	defer func() {
+		os.Remove("tests/download/assets.tar.gz")
+		os.Remove("tests/download/assets.zip")
+	}()
+	os.MkdirAll("tests/download", 0771)
+
+	runTestBuild(t, "buildcommands.bygg", "download/assets.tar.gz")
+	first, err := os.ReadFile("tests/download/assets.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Archives should not depend on file times
+	stat, err := os.Stat("tests/assets/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := stat.ModTime()
+	t.Cleanup(func() {
+		os.Chtimes("tests/assets/a.txt", modified, modified)
+	})
+	now := time.Now()
+	os.Chtimes("tests/assets/a.txt", now, now)
+	runTestBuild(t, "buildcommands.bygg", "download/assets.tar.gz")
+	second, err := os.ReadFile("tests/download/assets.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Error("tar archive is not reproducible")
+	}
+
+	runTestBuild(t, "buildcommands.bygg", "download/assets.zip")
+	reader, err := zip.OpenReader("tests/download/assets.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	names := []string{}
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+	expected := "[assets/ assets/a.txt assets/sub/ assets/sub/b.txt child.bygg]"
+	if fmt.Sprint(names) != expected {
+		t.Errorf("Expected: %v, got: %v", expected, names)
+	}
+
+	// Paths outside of the base dir are rejected
+	abs, err := filepath.Abs("tests/child.bygg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BYGG_TEST_ABS", abs)
+	verifyBuildFails(t, "buildcommands.bygg", "download/escape.tar")
+	verifyBuildFails(t, "buildcommands.bygg", "download/escape.zip")
+	if exists("tests/download/escape.tar") || exists("tests/download/escape.zip") {
+		t.Error("archive created from paths outside of the base dir")
+	}
+}
+
+func Test_checksum(t *testing.T) {
+	defer func() {
+		os.Remove("tests/download/SHA256SUMS")
+		os.Remove("tests/download/MD5SUMS")
+	}()
+	os.MkdirAll("tests/download", 0771)
+
+	runTestBuild(t, "buildcommands.bygg", "download/SHA256SUMS")
+	runTestBuild(t, "buildcommands.bygg", "download/MD5SUMS")
+	content, err := os.ReadFile("tests/download/SHA256SUMS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "" +
+		"9c18d9c57dcb2099be302862b495af14d493f923d581658e53bcc7936eed3ad7  assets/a.txt\n" +
+		"479a4a755c370c8fb19b2095346023bc380c9103b7f427752c9596a56eda4de4  assets/sub/b.txt\n"
+	if string(content) != expected {
+		t.Errorf("Expected: %q, got: %q", expected, content)
+	}
+
+	verifyTestOutput(t, "buildcommands.bygg", "verify", "")
+
+	os.WriteFile("tests/download/MD5SUMS", []byte("00000000000000000000000000000000  child.bygg\n"), 0644)
+	verifyBuildFails(t, "buildcommands.bygg", "verify")
+}
+
 func Test_emptyVersion(t *testing.T) {
 	FallbackTag = ""
 	verifyTestOutput(t, "version.bygg", "version", "OK\n")
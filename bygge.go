@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -18,6 +19,7 @@ import (
 type bygge struct {
 	lastError error
 	output    io.Writer
+	input     *bufio.Reader
 
 	targets map[string]target
 	vars    map[string]string
@@ -25,16 +27,82 @@ type bygge struct {
 	visited map[string]bool
 	tmpl    *template.Template
 
+	// Unexpanded values of deferred variables
+	deferred map[string]string
+
+	// Absolute paths of the importing bygg files, to detect cyclic imports
+	importing map[string]bool
+
+	// Files affecting template execution
+	scriptInputs map[string]bool
+	// Patterns and results of "glob" template function calls
+	globs map[string]string
+
+	ignores ignorePatterns
+
+	// Variables and environment shared by build commands
+	// defined between assignments
+	varsSnapshot map[string]string
+	envSnapshot  map[string]string
+
+	// Command started by the "-run" option in watch mode
+	running *runningCommand
+
 	cfg config
 }
 
 type target struct {
 	name          string
-	buildCommands []string
+	buildCommands []buildCommand
 	dependencies  []string
+	assignments   []assignment
 	resolved      bool
 	force         bool
+	phony         bool
+	output        bool
 	modifiedAt    time.Time
+
+	// Order-only dependencies, that are built first without
+	// affecting whether the target is outdated.
+	orderOnly []string
+
+	// All outputs of a multi-output target. The first output is the
+	// primary target, holding the dependencies and build commands.
+	outputs []string
+
+	// Imported targets are built in their own directory,
+	// using their local name and environment.
+	dir   string
+	local string
+	env   map[string]string
+}
+
+// buildCommand holds an unexpanded build command, together with the variables
+// and environment at the point where it was defined. Expanding when running
+// makes it possible to apply target specific assignments.
+type buildCommand struct {
+	command string
+	vars    map[string]string
+	env     map[string]string
+
+	// Variables of the defining script, used when expanding deferred variables
+	globals  map[string]string
+	deferred map[string]string
+}
+
+// assignment is a target specific variable assignment
+type assignment struct {
+	lvalue string
+	rvalue string
+	add    bool
+}
+
+// file returns the path of the target file, relative to the base dir.
+func (t target) file() string {
+	if t.dir == "" {
+		return t.name
+	}
+	return filepath.Join(t.dir, t.local)
 }
 
 func verifyVersion(byggFile string) error {
@@ -73,21 +141,44 @@ func newBygge(cfg config) (*bygge, error) {
 	defer os.Chdir(pwd)
 
 	result := &bygge{
-		targets: map[string]target{},
-		vars:    builtins,
-		env:     map[string]string{},
-		visited: map[string]bool{},
-		output:  os.Stdout,
-		cfg:     cfg,
+		output: os.Stdout,
+		input:  bufio.NewReader(os.Stdin),
+		cfg:    cfg,
 	}
 
-	if err := verifyVersion(cfg.byggFil); err != nil {
+	if err := result.init(); err != nil {
 		return nil, err
 	}
+	return result, nil
+}
+
+// init resets all build state and parses the bygg file template.
+// Expects the current directory to be the base dir.
+func (b *bygge) init() error {
+	b.targets = map[string]target{}
+	b.vars = map[string]string{}
+	b.deferred = map[string]string{}
+	b.env = map[string]string{}
+	b.visited = map[string]bool{}
+	b.scriptInputs = map[string]bool{b.cfg.byggFil: true, ignoreFile: true}
+	b.globs = map[string]string{}
+
+	var err error
+	if b.ignores, err = loadIgnorePatterns(ignoreFile); err != nil {
+		return err
+	}
+
+	for k, v := range builtins {
+		b.vars[k] = v
+	}
+
+	if err := verifyVersion(b.cfg.byggFil); err != nil {
+		return err
+	}
 
 	for _, pair := range os.Environ() {
 		parts := strings.SplitN(pair, "=", 2)
-		result.env[parts[0]] = parts[1]
+		b.env[parts[0]] = parts[1]
 	}
 
 	genExec := func(b *bygge, validate bool) func(string, ...interface{}) (string, error) {
@@ -159,13 +250,10 @@ func newBygge(cfg config) (*bygge, error) {
 				return strings.Join(array, joiner)
 			},
 			"glob": func(patterns ...string) []string {
-				result := []string{}
-				for _, pattern := range patterns {
-					if matches, err := filepath.Glob(pattern); err == nil {
-						result = append(result, matches...)
-					}
-				}
-				return result
+				return b.glob(false, patterns...)
+			},
+			"globignore": func(patterns ...string) []string {
+				return b.glob(true, patterns...)
 			},
 			"replace": func(pattern, replacement string, operands interface{}) interface{} {
 				re, err := regexp.Compile(pattern)
@@ -187,19 +275,74 @@ func newBygge(cfg config) (*bygge, error) {
 		}
 	}
 
-	result.tmpl = template.New(cfg.byggFil)
-	result.tmpl.Funcs(getFunctions(result))
+	b.tmpl = template.New(b.cfg.byggFil)
+	b.tmpl.Funcs(getFunctions(b))
 
-	result.verbose("Parsing template")
-	if !exists(cfg.byggFil) {
-		return nil, fmt.Errorf("bygg file %q not found", cfg.byggFil)
+	b.verbose("Parsing template")
+	if !exists(b.cfg.byggFil) {
+		return fmt.Errorf("bygg file %q not found", b.cfg.byggFil)
 	}
-	var err error
+	script, err := b.readScript(b.cfg.byggFil, map[string]bool{})
+	if err != nil {
+		return err
+	}
+	if _, err = b.tmpl.Parse(script); err != nil {
+		return fmt.Errorf("failed to parse templates: %w", err)
+	}
+	return nil
+}
+
+var includeExp = regexp.MustCompile(`^\s*include\s+([^\s:=<+]\S*)\s*$`)
 
-	if result.tmpl, err = result.tmpl.ParseFiles(cfg.byggFil); err != nil {
-		return nil, fmt.Errorf("failed to parse templates: %w", err)
+// readScript reads a bygg file, replacing include directives with invocations
+// of the included files, which are parsed into the same template set.
+// Included paths are relative to the including file, and can be glob patterns.
+func (b *bygge) readScript(file string, including map[string]bool) (string, error) {
+	if including[file] {
+		return "", fmt.Errorf("cyclic include of %q", file)
 	}
-	return result, nil
+	including[file] = true
+	defer delete(including, file)
+	b.scriptInputs[file] = true
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		matches := includeExp.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+		pattern := matches[1]
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(file), pattern)
+		}
+		files := []string{pattern}
+		if isGlob(pattern) {
+			files = b.glob(false, pattern)
+		}
+
+		invocations := []string{}
+		for _, included := range files {
+			if b.tmpl.Lookup(included) == nil {
+				b.verbose("Including %q", included)
+				script, err := b.readScript(included, including)
+				if err != nil {
+					return "", err
+				}
+				if _, err = b.tmpl.New(included).Parse(script); err != nil {
+					return "", fmt.Errorf("failed to parse templates: %w", err)
+				}
+			}
+			invocations = append(invocations, fmt.Sprintf("{{template %q .}}", included))
+		}
+		lines[i] = strings.Join(invocations, "")
+	}
+
+	return strings.Join(lines, "\n"), nil
 }
 
 func (b *bygge) buildTarget(tgt string) error {
@@ -208,7 +351,100 @@ func (b *bygge) buildTarget(tgt string) error {
 		return err
 	}
 	defer os.Chdir(pwd)
+	defer b.stopRunCommand()
+
+	if err := b.loadScript(); err != nil {
+		return err
+	}
+
+	t, ok := b.targets[tgt]
+	if !ok {
+		return fmt.Errorf("no such target %q", tgt)
+	}
+
+	runTarget := b.cfg.watch && b.cfg.run != "" && !b.cfg.dryRun
+
+	for {
+		err := b.resolve(t, nil)
+		// The running command is only restarted if the run target is up to date
+		if err == nil && runTarget {
+			err = b.resolveRunTarget()
+		}
+		if !b.cfg.watch {
+			return err
+		}
+		if err != nil {
+			fmt.Printf("%v\n", err)
+		} else if runTarget {
+			if err = b.restartRunCommand(); err != nil {
+				fmt.Printf("%v\n", err)
+			}
+		}
+		b.unresolve()
+		for {
+			fmt.Println("Waiting for changes")
+			waitStart := time.Now()
+			changed, err := b.waitForChange(t)
+			if err != nil {
+				return err
+			}
+			if len(changed) > 0 {
+				fmt.Printf("Changed: %s\n", strings.Join(changed, ", "))
+			}
+			if !b.scriptChangedSince(waitStart) {
+				break
+			}
+			// Keep waiting on the previous target if reloading fails
+			fmt.Println("Detected build script change, reloading...")
+			if err = b.reload(); err == nil {
+				if t, ok = b.targets[tgt]; ok {
+					break
+				}
+				err = fmt.Errorf("no such target %q", tgt)
+			}
+			fmt.Printf("%v\n", err)
+		}
+		fmt.Println("Detected change, rebuilding...")
+	}
+}
+
+// glob implements the "glob" template functions, recording the
+// results to be able to detect changes in watch mode.
+func (b *bygge) glob(honourIgnores bool, patterns ...string) []string {
+	result := []string{}
+	for _, pattern := range patterns {
+		matches := globFiles(pattern)
+		b.globs[pattern] = strings.Join(b.ignores.filter(matches), " ")
+		if honourIgnores {
+			matches = b.ignores.filter(matches)
+		}
+		result = append(result, matches...)
+	}
+	return result
+}
 
+// reload re-runs template execution and loading of the build script.
+// The script is loaded into a fresh state, that replaces the current
+// state only if loading succeeds.
+func (b *bygge) reload() error {
+	fresh := &bygge{
+		output: b.output,
+		input:  b.input,
+		cfg:    b.cfg,
+	}
+	if err := fresh.init(); err != nil {
+		return err
+	}
+	if err := fresh.loadScript(); err != nil {
+		return err
+	}
+	fresh.running = b.running
+	*b = *fresh
+	return nil
+}
+
+// loadScript executes the template and loads the resulting build script.
+func (b *bygge) loadScript() error {
 	data := map[string]interface{}{
 		"env": b.env,
 	}
@@ -230,31 +466,7 @@ func (b *bygge) buildTarget(tgt string) error {
 	lineJoiner.WriteString(&joined, buf.String())
 
 	b.verbose("Loading build script")
-	if err := b.loadBuildScript(&joined); err != nil {
-		return err
-	}
-
-	if tgt, ok := b.targets[tgt]; ok {
-		for {
-			err := b.resolve(tgt)
-			if b.cfg.watch {
-				b.unresolve()
-				if err != nil {
-					fmt.Printf("%v\n", err)
-				}
-				fmt.Println("Waiting for changes")
-				err = b.waitForChange(tgt)
-				if err != nil {
-					return err
-				}
-				fmt.Println("Detected change, rebuilding...")
-			} else {
-				return err
-			}
-		}
-	}
-
-	return fmt.Errorf("no such target %q", tgt)
+	return b.loadBuildScript(&joined)
 }
 
 func (b *bygge) loadBuildScript(scriptSource io.Reader) error {
@@ -268,10 +480,24 @@ func (b *bygge) loadBuildScript(scriptSource io.Reader) error {
 	// all <- gcc -o all all.c
 	// bar=baz
 	// bar += yes
-	commandExp := regexp.MustCompile(`([\w._\-/${}]+)\s*([:=]|\+=|<-|<<)\s*(.*)`)
+	// lazy ~= $(later)
+	commandExp := regexp.MustCompile(`([\w._\-/${}]+)\s*([:=]|\+=|~=|<-|<<)\s*(.*)`)
+
+	// import sub/byggfil as sub
+	importExp := regexp.MustCompile(`^import\s+(\S+)\s+as\s+(\S+)$`)
+
+	// Multi-output targets
+	// parser.c parser.h: parser.y
+	// parser.c parser.h <- yacc -d parser.y
+	multiOutputExp := regexp.MustCompile(`^((?:[\w._\-/${}]+\s+)+[\w._\-/${}]+)\s*(:|<-|<<)\s*(.*)$`)
 
+	// Nested if/else/endif blocks
+	conditions := conditionals{}
+
+	lineNumber := 0
 	for scanner.Scan() {
 		line := scanner.Text()
+		lineNumber++
 		// Skip initial whitespace
 		line = strings.TrimLeft(line, " \t")
 		// Skip comments
@@ -282,9 +508,58 @@ func (b *bygge) loadBuildScript(scriptSource io.Reader) error {
 		if line == "" {
 			continue
 		}
+		// Handle conditionals, and skip lines in branches not taken
+		if handled, err := b.handleConditional(line, &conditions); handled || err != nil {
+			if err != nil {
+				return lineError(err, lineNumber, line)
+			}
+			continue
+		}
+		if !conditions.active() {
+			continue
+		}
 		// Handle message lines
 		if strings.HasPrefix(line, "<<") {
-			fmt.Fprintln(b.output, b.expand(strings.Trim(line[2:], " \t")))
+			message, err := b.expand(strings.Trim(line[2:], " \t"))
+			if err != nil {
+				return lineError(err, lineNumber, line)
+			}
+			fmt.Fprintln(b.output, message)
+			continue
+		}
+
+		if matches := importExp.FindStringSubmatch(line); matches != nil {
+			file, err := b.expand(matches[1])
+			if err != nil {
+				return lineError(err, lineNumber, line)
+			}
+			alias, err := b.expand(matches[2])
+			if err != nil {
+				return lineError(err, lineNumber, line)
+			}
+			if err := b.handleImport(file, alias); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if matches := multiOutputExp.FindStringSubmatch(line); matches != nil {
+			outputs, err := b.expand(matches[1])
+			if err != nil {
+				return lineError(err, lineNumber, line)
+			}
+			rvalue := matches[3]
+			if matches[2] == ":" {
+				if rvalue, err = b.expand(rvalue); err != nil {
+					return lineError(err, lineNumber, line)
+				}
+			}
+			if len(strings.Fields(outputs)) == 0 {
+				return lineError(fmt.Errorf("parse error: no outputs"), lineNumber, line)
+			}
+			if err := b.handleMultiOutput(strings.Fields(outputs), matches[2], rvalue); err != nil {
+				return err
+			}
 			continue
 		}
 
@@ -297,10 +572,19 @@ func (b *bygge) loadBuildScript(scriptSource io.Reader) error {
 		operator := matches[2]
 		rvalue := matches[3]
 
-		lvalue = b.expand(lvalue)
-		rvalue = b.expand(rvalue)
+		lvalue, err := b.expand(lvalue)
+		if err != nil {
+			return lineError(err, lineNumber, line)
+		}
+		// Build commands and deferred variables are expanded when used
+		deferred := operator == "~=" || operator == "+=" && b.isDeferred(lvalue)
+		if operator != "<-" && operator != "<<" && !deferred {
+			rvalue, err = b.expand(rvalue)
+			if err != nil {
+				return lineError(err, lineNumber, line)
+			}
+		}
 
-		var err error
 		switch operator {
 		case ":":
 			err = b.handleDependencies(lvalue, rvalue)
@@ -308,6 +592,8 @@ func (b *bygge) loadBuildScript(scriptSource io.Reader) error {
 			err = b.handleAssignment(lvalue, rvalue, false)
 		case "+=":
 			err = b.handleAssignment(lvalue, rvalue, true)
+		case "~=":
+			err = b.handleDeferredAssignment(lvalue, rvalue)
 		case "<<":
 			rvalue = operator + " " + rvalue
 			fallthrough
@@ -322,81 +608,257 @@ func (b *bygge) loadBuildScript(scriptSource io.Reader) error {
 		}
 	}
 
+	if len(conditions) > 0 {
+		return fmt.Errorf("missing \"endif\"")
+	}
+
+	return nil
+}
+
+// lineError adds the offending byggfil line to an error
+func lineError(err error, lineNumber int, line string) error {
+	return fmt.Errorf("%w, in line %d: %q", err, lineNumber, line)
+}
+
+// handleMultiOutput declares a target with several outputs, that are all
+// produced by running the build commands of the primary target once.
+func (b *bygge) handleMultiOutput(outputs []string, operator, rvalue string) error {
+	outputs = cleanPaths(outputs...)
+	primary := b.primaryTarget(outputs[0])
+
+	phony := false
+	for _, output := range outputs {
+		if output == primary {
+			continue
+		}
+		t := b.targets[output]
+		// Outputs declared phony before the rule make the whole target phony
+		phony = phony || t.phony
+		if len(t.outputs) > 0 && t.outputs[0] != primary {
+			return fmt.Errorf("%q is already an output of %q", output, t.outputs[0])
+		}
+		if len(t.outputs) == 0 && (len(t.buildCommands) > 0 || len(t.dependencies) > 0) {
+			return fmt.Errorf("%q is already a target", output)
+		}
+		if len(t.outputs) == 0 {
+			t.name = output
+			t.outputs = []string{primary}
+			t.dependencies = []string{primary}
+			b.targets[output] = t
+		}
+	}
+
+	t := b.targets[primary]
+	t.name = primary
+	t.phony = t.phony || phony
+	if len(t.outputs) == 0 {
+		t.outputs = []string{primary}
+	}
+	for _, output := range outputs {
+		if !containsString(t.outputs, output) {
+			t.outputs = append(t.outputs, output)
+		}
+	}
+	b.targets[primary] = t
+
+	switch operator {
+	case ":":
+		return b.handleDependencies(primary, rvalue)
+	case "<<":
+		rvalue = operator + " " + rvalue
+	}
+	b.handleBuildCommand(primary, rvalue)
 	return nil
 }
 
+// primaryTarget returns the name of the primary target of a multi-output
+// target, or the name itself for other targets.
+func (b *bygge) primaryTarget(name string) string {
+	if t, ok := b.targets[name]; ok && len(t.outputs) > 0 {
+		return t.outputs[0]
+	}
+	return name
+}
+
+// outputFiles lists the files produced by a target.
+func (b *bygge) outputFiles(t target) []string {
+	if len(t.outputs) == 0 || t.outputs[0] != t.name {
+		return []string{t.file()}
+	}
+	files := []string{}
+	for _, output := range t.outputs {
+		files = append(files, b.targets[output].file())
+	}
+	return files
+}
+
+// Targets listed as dependencies of this target are not files
+const phonyTarget = ".phony"
+
+// Targets listed as dependencies of this target are files generated by their
+// build commands, and are removed by "-clean"
+const outputsTarget = ".outputs"
+
 func (b *bygge) handleDependencies(lvalue, rvalue string) error {
-	clean := cleanPaths(lvalue)[0]
+	clean := b.primaryTarget(cleanPaths(lvalue)[0])
+	if clean == phonyTarget {
+		return b.handlePhony(rvalue)
+	}
+	if clean == outputsTarget {
+		return b.handleOutputs(rvalue)
+	}
 	t := b.targets[clean]
 	t.name = clean
 	rvalue = strings.TrimLeft(rvalue, " \t")
+	if matches := scopedAssignmentExp.FindStringSubmatch(rvalue); matches != nil {
+		t.assignments = append(t.assignments, assignment{
+			lvalue: matches[1],
+			rvalue: matches[3],
+			add:    matches[2] == "+=",
+		})
+		b.targets[clean] = t
+		return nil
+	}
 	if strings.HasPrefix(rvalue, "!") {
 		t.force = true
 		rvalue = strings.TrimLeft(rvalue, "!")
 	}
+	orderOnly := ""
+	if bar := strings.Index(rvalue, "|"); bar >= 0 {
+		orderOnly = rvalue[bar+1:]
+		rvalue = rvalue[:bar]
+	}
 	dependencies, err := splitQuoted(rvalue)
 	if err != nil {
 		return err
 	}
 	dependencies = cleanPaths(dependencies...)
 	t.dependencies = append(t.dependencies, dependencies...)
+	if orderOnly != "" {
+		dependencies, err = splitQuoted(orderOnly)
+		if err != nil {
+			return err
+		}
+		t.orderOnly = append(t.orderOnly, cleanPaths(dependencies...)...)
+	}
 	b.targets[clean] = t
 
 	return nil
 }
 
+// handlePhony marks targets as not producing any file. Phony targets are always built.
+func (b *bygge) handlePhony(rvalue string) error {
+	names, err := splitQuoted(rvalue)
+	if err != nil {
+		return err
+	}
+	for _, name := range cleanPaths(names...) {
+		// Multi-output targets are handled by their primary target
+		name = b.primaryTarget(name)
+		t := b.targets[name]
+		t.name = name
+		t.phony = true
+		b.targets[name] = t
+	}
+	return nil
+}
+
+// handleOutputs marks targets as generated by their build commands,
+// for targets built by external commands.
+func (b *bygge) handleOutputs(rvalue string) error {
+	names, err := splitQuoted(rvalue)
+	if err != nil {
+		return err
+	}
+	for _, name := range cleanPaths(names...) {
+		// Multi-output targets are handled by their primary target
+		name = b.primaryTarget(name)
+		t := b.targets[name]
+		t.name = name
+		t.output = true
+		b.targets[name] = t
+	}
+	return nil
+}
+
+// Target specific assignments, like "target: CFLAGS += -g"
+var scopedAssignmentExp = regexp.MustCompile(`^([A-Za-z_]\w*(?:\.\w+)?)\s*(=|\+=)\s*(.*)$`)
+
 func (b *bygge) handleAssignment(lvalue, rvalue string, add bool) error {
+	b.varsSnapshot = nil
+	b.envSnapshot = nil
+	// Adding to a deferred variable keeps it deferred
+	if add && b.isDeferred(lvalue) {
+		b.deferred[lvalue] += " " + rvalue
+		return nil
+	}
+	return assign(b.vars, b.env, lvalue, rvalue, add)
+}
+
+// isDeferred checks if a variable is currently set by a deferred assignment.
+func (b *bygge) isDeferred(name string) bool {
+	_, isSet := b.vars[name]
+	_, isDeferred := b.deferred[name]
+	return isDeferred && !isSet
+}
+
+// handleDeferredAssignment sets a variable that is expanded each time it is used,
+// instead of when assigned.
+func (b *bygge) handleDeferredAssignment(lvalue, rvalue string) error {
+	if strings.Contains(lvalue, ".") {
+		return fmt.Errorf("deferred assignment not supported for %q", lvalue)
+	}
+	b.varsSnapshot = nil
+	b.envSnapshot = nil
+	delete(b.vars, lvalue)
+	b.deferred[lvalue] = rvalue
+	return nil
+}
+
+func assign(vars, env map[string]string, lvalue, rvalue string, add bool) error {
 	if strings.Contains(lvalue, ".") {
 		parts := strings.SplitN(lvalue, ".", 2)
 		context := parts[0]
 		name := parts[1]
 		if context == "env" {
-			if oldValue, isSet := b.env[name]; isSet && add {
+			if oldValue, isSet := env[name]; isSet && add {
 				rvalue = oldValue + " " + rvalue
 			}
-			b.env[name] = rvalue
+			env[name] = rvalue
 		} else {
 			return fmt.Errorf("unknown variable context %q", context)
 		}
 	} else {
 		if add {
-			rvalue = b.vars[lvalue] + " " + rvalue
+			rvalue = vars[lvalue] + " " + rvalue
 		}
-		b.vars[lvalue] = rvalue
+		vars[lvalue] = rvalue
 	}
 
 	return nil
 }
 
 func (b *bygge) handleBuildCommand(lvalue, rvalue string) {
-	clean := cleanPaths(lvalue)[0]
+	clean := b.primaryTarget(cleanPaths(lvalue)[0])
 	t := b.targets[clean]
 	t.name = clean
-	t.buildCommands = append(t.buildCommands, rvalue)
-	b.targets[clean] = t
-}
-
-// Permissive variable expansion
-func (b *bygge) expand(expr string) string {
-	return os.Expand(expr, func(varExpr string) string {
-		varExpr = strings.Trim(varExpr, " \t")
-		if strings.Contains(varExpr, ".") {
-			parts := strings.SplitN(varExpr, ".", 2)
-			context := parts[0]
-			name := parts[1]
-
-			if context == "env" {
-				if local, ok := b.env[name]; ok {
-					return local
-				}
-			}
-			return ""
-		}
-		return b.vars[varExpr]
+	if b.varsSnapshot == nil {
+		b.varsSnapshot = copyMap(b.vars)
+		b.envSnapshot = copyMap(b.env)
+	}
+	t.buildCommands = append(t.buildCommands, buildCommand{
+		command:  rvalue,
+		vars:     b.varsSnapshot,
+		env:      b.envSnapshot,
+		globals:  b.vars,
+		deferred: b.deferred,
 	})
+	b.targets[clean] = t
 }
 
-func (b *bygge) resolve(t target) error {
+// resolve builds the target if it is outdated, after resolving its dependencies.
+// Target specific assignments are inherited from the dependent targets.
+func (b *bygge) resolve(t target, inherited []assignment) error {
 	if t.resolved {
 		return nil
 	}
@@ -411,46 +873,64 @@ func (b *bygge) resolve(t target) error {
 	}()
 
 	dependencies := t.dependencies
+	scope := append(inherited[:len(inherited):len(inherited)], t.assignments...)
 
 	var mostRecentUpdate time.Time
 
-	for _, depName := range dependencies {
-		dep, ok := b.targets[depName]
-		if !ok {
-			if exists(depName) {
-				dep = target{
-					name: depName,
-				}
-			} else {
-				return fmt.Errorf("target %q has unknown dependency %q", t.name, depName)
-			}
+	for _, depName := range t.orderOnly {
+		if err := b.resolveDependency(t, depName, scope); err != nil {
+			return err
 		}
-		if err := b.resolve(dep); err != nil {
+	}
+
+	for _, depName := range dependencies {
+		if err := b.resolveDependency(t, depName, scope); err != nil {
 			return err
 		}
-		dep = b.targets[depName]
+		dep := b.targets[depName]
 		if dep.modifiedAt.After(mostRecentUpdate) {
 			mostRecentUpdate = dep.modifiedAt
 		}
 	}
 
-	if t.force || !exists(t.name) || getFileDate(t.name).Before(mostRecentUpdate) {
+	// Multi-output targets are outdated if any of their outputs is
+	files := b.outputFiles(t)
+	outdated := t.force || t.phony || runsGit(t)
+	for _, file := range files {
+		if !exists(file) || getFileDate(file).Before(mostRecentUpdate) {
+			outdated = true
+		}
+	}
+	if outdated {
 		if len(t.buildCommands) == 0 {
 			b.verbose("No build command for target %q, skipping build", t.name)
 		}
-		for _, cmd := range t.buildCommands {
-			if err := b.runBuildCommand(t.name, cmd); err != nil {
-				return err
-			}
+		if err := b.runTargetCommands(t, scope); err != nil {
+			return err
+		}
+		if err := b.verifyOutputs(t, files); err != nil {
+			return err
 		}
 	}
 
 	t.resolved = true
 
-	if exists(t.name) {
-		t.modifiedAt = getFileDate(t.name)
-	} else {
-		t.modifiedAt = time.Now()
+	t.modifiedAt = time.Time{}
+	for _, file := range files {
+		var modified time.Time
+		if t.phony {
+			modified = time.Now()
+		} else if isTreeDependency(file) {
+			modified = b.dependencyDate(file)
+		} else if exists(file) {
+			modified = getFileDate(file)
+		} else {
+			modified = time.Now()
+		}
+		// Multi-output targets are as old as their oldest output
+		if t.modifiedAt.IsZero() || modified.Before(t.modifiedAt) {
+			t.modifiedAt = modified
+		}
 	}
 
 	b.targets[t.name] = t
@@ -458,17 +938,162 @@ func (b *bygge) resolve(t target) error {
 	return nil
 }
 
-func (b *bygge) runBuildCommand(tgt, command string) error {
-	if b.cfg.dryRun {
-		fmt.Printf("Not running command %q\n", command)
+// verifyOutputs checks that the build commands of a non-phony target produced
+// the target file. Missing files are errors in strict mode, otherwise warnings.
+func (b *bygge) verifyOutputs(t target, files []string) error {
+	if t.phony || b.cfg.dryRun || !runsCommands(t) {
 		return nil
 	}
+	for _, file := range files {
+		if isTreeDependency(file) || exists(file) {
+			continue
+		}
+		if b.cfg.strict {
+			return fmt.Errorf("build commands for %q did not produce %q", t.name, file)
+		}
+		fmt.Fprintf(b.output, "Warning: build commands for %q did not produce %q, declare phony targets using %q\n", t.name, file, phonyTarget)
+	}
+	return nil
+}
+
+func (b *bygge) resolveDependency(t target, depName string, scope []assignment) error {
+	dep, ok := b.targets[depName]
+	if !ok {
+		if exists(depName) || (isTreeDependency(depName) && exists(filepath.Dir(depName))) {
+			dep = target{
+				name: depName,
+			}
+		} else {
+			return fmt.Errorf("target %q has unknown dependency %q", t.name, depName)
+		}
+	}
+	return b.resolve(dep, scope)
+}
+
+// runTargetCommands runs the build commands of a target, applying target specific
+// assignments. Imported targets are built in their own directory, using their
+// own environment.
+func (b *bygge) runTargetCommands(t target, scope []assignment) error {
+	name := t.name
+	if t.dir != "" {
+		name = t.local
+		pwd, _ := os.Getwd()
+		if err := os.Chdir(t.dir); err != nil {
+			return err
+		}
+		defer os.Chdir(pwd)
+	}
+
+	runEnv, err := b.targetEnv(t, scope)
+	if err != nil {
+		return err
+	}
+	env := b.env
+	b.env = runEnv
+	defer func() {
+		b.env = env
+	}()
+
+	for _, cmd := range t.buildCommands {
+		command, err := b.expandCommand(cmd, scope, runEnv)
+		if err != nil {
+			return err
+		}
+		if err := b.runBuildCommand(name, command); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targetEnv returns the environment for running the build commands of a target.
+func (b *bygge) targetEnv(t target, scope []assignment) (map[string]string, error) {
+	runEnv := b.env
+	if t.env != nil {
+		runEnv = t.env
+	}
+	if len(scope) > 0 {
+		runEnv = copyMap(runEnv)
+		for _, a := range scope {
+			if err := assign(map[string]string{}, runEnv, a.lvalue, a.rvalue, a.add); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return runEnv, nil
+}
+
+// expandCommand expands a build command, applying target specific assignments.
+func (b *bygge) expandCommand(cmd buildCommand, scope []assignment, runEnv map[string]string) (string, error) {
+	vars, env, globals := cmd.vars, cmd.env, cmd.globals
+	if len(scope) > 0 {
+		vars, env, globals = copyMap(vars), copyMap(env), copyMap(globals)
+		for _, a := range scope {
+			if err := assign(vars, env, a.lvalue, a.rvalue, a.add); err != nil {
+				return "", err
+			}
+			if err := assign(globals, map[string]string{}, a.lvalue, a.rvalue, a.add); err != nil {
+				return "", err
+			}
+		}
+	}
+	// Deferred variables are expanded using the final values
+	// of the variables, like build commands in "make".
+	late := b.newExpander(globals, runEnv, cmd.deferred)
+	expander := b.newExpander(vars, env, cmd.deferred)
+	expander.late = &late
+	command, err := expander.expand(cmd.command)
+	if err != nil {
+		return "", fmt.Errorf("%w, in build command %q", err, cmd.command)
+	}
+	return command, nil
+}
+
+// isTreeDependency checks for "dir/**" dependencies, that track
+// changes to all files in a directory tree.
+func isTreeDependency(name string) bool {
+	return filepath.Base(name) == "**"
+}
+
+// dependencyDate returns the modification time of a file, or the most
+// recent modification time in the directory tree of a tree dependency.
+func (b *bygge) dependencyDate(name string) time.Time {
+	if !isTreeDependency(name) {
+		return getFileDate(name)
+	}
+	var mostRecentUpdate time.Time
+	_ = filepath.Walk(filepath.Dir(name), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if b.ignores.matches(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.ModTime().After(mostRecentUpdate) {
+			mostRecentUpdate = info.ModTime()
+		}
+		return nil
+	})
+	return mostRecentUpdate
+}
+
+func (b *bygge) runBuildCommand(tgt, command string) error {
 	parts, err := splitQuoted(command)
 	if err != nil {
 		return err
 	}
 	prog := parts[0]
 	args := parts[1:]
+	if b.cfg.dryRun {
+		if strings.HasPrefix(prog, "clean:") {
+			return b.handleClean(prog, args...)
+		}
+		fmt.Printf("Not running command %q\n", command)
+		return nil
+	}
 	b.verbose("Running command %q with args %v", prog, args)
 	if prog == "<<" {
 		fmt.Fprintln(b.output, strings.Join(args, " "))
@@ -486,7 +1111,7 @@ func (b *bygge) runBuildCommand(tgt, command string) error {
 		bb.output = b.output
 		return bb.buildTarget(cfg.target)
 	}
-	if strings.HasPrefix(prog, "http") {
+	if strings.HasPrefix(prog, "http") || strings.HasPrefix(prog, "file://") {
 		return b.handleDownload(tgt, prog, args...)
 	}
 	if strings.HasPrefix(prog, "clean:") {
@@ -498,6 +1123,36 @@ func (b *bygge) runBuildCommand(tgt, command string) error {
 	if strings.HasPrefix(prog, "copy:") {
 		return b.handleCopy(tgt, prog, args...)
 	}
+	if strings.HasPrefix(prog, "move:") {
+		return b.handleMove(tgt, prog, args...)
+	}
+	if strings.HasPrefix(prog, "touch:") {
+		return b.handleTouch(tgt, prog, args...)
+	}
+	if strings.HasPrefix(prog, "symlink:") {
+		return b.handleSymlink(tgt, prog, args...)
+	}
+	if strings.HasPrefix(prog, "write:") {
+		return b.handleWrite(tgt, prog, args...)
+	}
+	if strings.HasPrefix(prog, "tar:") {
+		return b.handleTar(tgt, prog, args...)
+	}
+	if strings.HasPrefix(prog, "zip:") {
+		return b.handleZip(tgt, prog, args...)
+	}
+	if strings.HasPrefix(prog, "checksum:") {
+		return b.handleChecksum(tgt, prog, args...)
+	}
+	if strings.HasPrefix(prog, "verify:") {
+		return b.handleVerify(tgt, prog, args...)
+	}
+	if strings.HasPrefix(prog, "unpack:") {
+		return b.handleUnpack(tgt, prog, args...)
+	}
+	if strings.HasPrefix(prog, "git:") {
+		return b.handleGit(tgt, prog, args...)
+	}
 
 	cmd := exec.Command(prog, args...)
 	cmd.Env = b.envList()
@@ -508,8 +1163,12 @@ func (b *bygge) runBuildCommand(tgt, command string) error {
 }
 
 func (b *bygge) envList() []string {
+	return envList(b.env)
+}
+
+func envList(vars map[string]string) []string {
 	env := []string{}
-	for k, v := range b.env {
+	for k, v := range vars {
 		if k == "" {
 			continue
 		}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// handleChecksum writes a "sha256sum" compatible manifest of the given files to the target.
+func (b *bygge) handleChecksum(target string, cmd string, args ...string) error {
+	algorithm := "sha256"
+	patterns := []string{}
+	first := strings.TrimPrefix(cmd, "checksum:")
+	first = strings.TrimSpace(first)
+	if len(first) > 0 {
+		patterns = append(patterns, first)
+	}
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			algorithm = strings.TrimPrefix(arg, "-")
+			if _, ok := digests[algorithm]; !ok {
+				return fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+			}
+		} else {
+			patterns = append(patterns, arg)
+		}
+	}
+	if len(patterns) == 0 {
+		return fmt.Errorf("Nothing to checksum")
+	}
+
+	files, err := expandGlobs(patterns...)
+	if err != nil {
+		return err
+	}
+
+	var manifest strings.Builder
+	for _, file := range files {
+		if filepath.Clean(file) == filepath.Clean(target) {
+			continue
+		}
+		sum, err := fileDigest(file, digests[algorithm])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&manifest, "%s  %s\n", sum, filepath.ToSlash(file))
+	}
+
+	return ioutil.WriteFile(target, []byte(manifest.String()), 0666)
+}
+
+// handleVerify checks all files listed in a checksum manifest, failing if any of them differ.
+// The algorithm is given by the length of the checksums.
+func (b *bygge) handleVerify(target string, cmd string, args ...string) error {
+	manifest := strings.TrimPrefix(cmd, "verify:")
+	manifest = strings.TrimSpace(manifest)
+	if len(manifest) == 0 {
+		if len(args) == 0 {
+			return fmt.Errorf("No checksum file specified")
+		}
+		manifest = strings.TrimSpace(args[0])
+	}
+
+	file, err := os.Open(manifest)
+	if err != nil {
+		return fmt.Errorf("Failed to read checksum file: %w", err)
+	}
+	defer file.Close()
+
+	failed := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid checksum line: %q", line)
+		}
+		expected := parts[0]
+		name := strings.TrimPrefix(strings.TrimSpace(parts[1]), "*")
+
+		newHash := digestForLength(len(expected))
+		if newHash == nil {
+			return fmt.Errorf("unsupported checksum for %q", name)
+		}
+		sum, err := fileDigest(name, newHash)
+		if err != nil || !strings.EqualFold(sum, expected) {
+			failed = append(failed, name)
+			continue
+		}
+		b.verbose("%s: OK", name)
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("checksum verification failed for %q", failed)
+	}
+	return nil
+}
+
+func fileDigest(name string, newHash func() hash.Hash) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return computeDigest(file, newHash)
+}
+
+func digestForLength(length int) func() hash.Hash {
+	for _, newHash := range digests {
+		if newHash().Size()*2 == length {
+			return newHash
+		}
+	}
+	return nil
+}
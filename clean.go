@@ -4,25 +4,215 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 )
 
 func (b *bygge) handleClean(cmd string, args ...string) error {
+	patterns := []string{}
 	path := strings.TrimPrefix(cmd, "clean:")
-	stat, err := os.Stat(path)
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
+	path = strings.TrimSpace(path)
+	if len(path) > 0 {
+		patterns = append(patterns, path)
+	}
+	recursive := false
+	outside := false
+	for _, arg := range args {
+		switch arg {
+		case "-r":
+			recursive = true
+		case "-outside":
+			outside = true
+		default:
+			patterns = append(patterns, arg)
+		}
+	}
+	if len(patterns) == 0 {
+		return fmt.Errorf("Nothing to clean")
+	}
+
+	paths := []string{}
+	for _, pattern := range patterns {
+		if !isGlob(pattern) {
+			paths = append(paths, pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, matches...)
+	}
+
+	for _, path := range paths {
+		if !outside {
+			if err := checkInsideBaseDir(path); err != nil {
+				return fmt.Errorf("%v. Use \"-outside\" to allow", err)
+			}
+		}
+		stat, err := os.Lstat(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if stat == nil {
+			continue
+		}
+		if stat.IsDir() && !recursive {
+			return fmt.Errorf("%q is a directory and \"-r\" was not specified", path)
+		}
+		if b.cfg.dryRun {
+			fmt.Fprintf(b.output, "Would remove %q\n", path)
+			continue
+		}
+		b.verbose("Removing %q", path)
+		if err = os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleanTarget removes the outputs of all targets that are reachable from the given
+// target. Directories are only removed after confirmation.
+func (b *bygge) cleanTarget(tgt string) error {
+	pwd, _ := os.Getwd()
+	if err := os.Chdir(b.cfg.baseDir); err != nil {
+		return err
+	}
+	defer os.Chdir(pwd)
+
+	if err := b.loadScript(); err != nil {
 		return err
 	}
-	if stat == nil {
-		return nil
+
+	t, ok := b.targets[tgt]
+	if !ok {
+		return fmt.Errorf("no such target %q", tgt)
+	}
+
+	outputs := map[string]bool{}
+	b.collectOutputs(t, outputs)
+
+	paths := []string{}
+	for path := range outputs {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if !exists(path) || filepath.Clean(path) == filepath.Clean(b.cfg.byggFil) {
+			continue
+		}
+		if err := checkInsideBaseDir(path); err != nil {
+			return fmt.Errorf("%v, check the outputs of the build script", err)
+		}
+		if b.cfg.dryRun {
+			fmt.Fprintf(b.output, "Would remove %q\n", path)
+			continue
+		}
+		if stat, err := os.Stat(path); err == nil && stat.IsDir() && !b.confirm(fmt.Sprintf("Remove directory %q?", path)) {
+			fmt.Fprintf(b.output, "Skipping %q\n", path)
+			continue
+		}
+		b.verbose("Removing %q", path)
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectOutputs finds all targets reachable from t that are known to be generated,
+// either by internal commands creating files, or by being declared using ".outputs".
+func (b *bygge) collectOutputs(t target, outputs map[string]bool) {
+	if b.visited[t.name] {
+		return
+	}
+	b.visited[t.name] = true
+	defer func() {
+		b.visited[t.name] = false
+	}()
+
+	if !t.phony && (t.output || createsOutput(t)) {
+		for _, file := range b.outputFiles(t) {
+			outputs[file] = true
+		}
+	}
+
+	for _, dependencies := range [][]string{t.dependencies, t.orderOnly} {
+		for _, depName := range dependencies {
+			if dep, ok := b.targets[depName]; ok {
+				b.collectOutputs(dep, outputs)
+			}
+		}
+	}
+}
+
+// Internal commands that create files or directories
+var creatingCommands = []string{
+	"http", "file://", "unpack:", "git:", "mkdir:", "copy:", "move:",
+	"touch:", "symlink:", "write:", "tar:", "zip:", "checksum:",
+}
+
+// createsOutput checks if a target has internal build commands that create files.
+// Targets built by external commands are not known to create their files.
+func createsOutput(t target) bool {
+	for _, cmd := range t.buildCommands {
+		for _, prefix := range creatingCommands {
+			if strings.HasPrefix(cmd.command, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runsCommands checks if a target has build commands other than messages,
+// clean and verify commands, none of which create outputs.
+func runsCommands(t target) bool {
+	for _, cmd := range t.buildCommands {
+		switch {
+		case strings.HasPrefix(cmd.command, "<<"):
+		case strings.HasPrefix(cmd.command, "clean:"):
+		case strings.HasPrefix(cmd.command, "verify:"):
+		default:
+			return true
+		}
 	}
-	recursive := len(args) > 0 && args[0] == "-r"
-	if stat.IsDir() && !recursive {
-		return fmt.Errorf("%q is a directory and \"-r\" was not specified", path)
+	return false
+}
+
+// checkInsideBaseDir verifies that a path is located below the
+// current directory, which is the base dir while building.
+func checkInsideBaseDir(path string) error {
+	base, err := filepath.Abs(".")
+	if err != nil {
+		return err
 	}
-	err = os.RemoveAll(path)
+	if resolved, err := filepath.EvalSymlinks(base); err == nil {
+		base = resolved
+	}
+	abs, err := filepath.Abs(path)
 	if err != nil {
 		return err
 	}
+	// Resolve links in the parent directories, the path itself is removed
+	// as a link, not followed.
+	if parent, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		abs = filepath.Join(parent, filepath.Base(abs))
+	}
+	rel, err := filepath.Rel(base, abs)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("Will not remove %q, it is not inside the base dir", path)
+	}
 	return nil
 }
+
+// confirm asks a yes or no question, defaulting to no.
+func (b *bygge) confirm(question string) bool {
+	fmt.Fprintf(b.output, "%s [y/N] ", question)
+	answer, _ := b.input.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
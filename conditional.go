@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// if $GOOS == windows
+// if $CC != gcc
+// if defined CFLAGS
+// if exists path/to/file
+var ifExp = regexp.MustCompile(`^if\s+([^\s=:+<].*)$`)
+var comparisonExp = regexp.MustCompile(`^(.*?)\s*(==|!=)\s*(.*)$`)
+
+// conditional tracks the state of an if/else/endif block
+type conditional struct {
+	// Set if the enclosing block is active
+	parentActive bool
+	// Set if the current branch is taken
+	active bool
+	// Set after "else"
+	inElse bool
+}
+
+type conditionals []conditional
+
+// active checks if lines in the current block should be handled
+func (c conditionals) active() bool {
+	if len(c) == 0 {
+		return true
+	}
+	top := c[len(c)-1]
+	return top.parentActive && top.active
+}
+
+// handleConditional handles if, else and endif lines.
+// Returns false if the line is not a conditional.
+func (b *bygge) handleConditional(line string, stack *conditionals) (bool, error) {
+	switch strings.TrimRight(line, " \t") {
+	case "else":
+		if len(*stack) == 0 {
+			return true, fmt.Errorf("\"else\" without \"if\"")
+		}
+		top := &(*stack)[len(*stack)-1]
+		if top.inElse {
+			return true, fmt.Errorf("multiple \"else\" for the same \"if\"")
+		}
+		top.inElse = true
+		top.active = !top.active
+		return true, nil
+	case "endif":
+		if len(*stack) == 0 {
+			return true, fmt.Errorf("\"endif\" without \"if\"")
+		}
+		*stack = (*stack)[:len(*stack)-1]
+		return true, nil
+	}
+
+	matches := ifExp.FindStringSubmatch(line)
+	if matches == nil {
+		return false, nil
+	}
+
+	parentActive := stack.active()
+	result := false
+	if parentActive {
+		var err error
+		result, err = b.evaluateCondition(strings.TrimSpace(matches[1]))
+		if err != nil {
+			return true, err
+		}
+	}
+	*stack = append(*stack, conditional{
+		parentActive: parentActive,
+		active:       result,
+	})
+	return true, nil
+}
+
+func (b *bygge) evaluateCondition(condition string) (bool, error) {
+	if strings.HasPrefix(condition, "defined ") {
+		name := strings.TrimSpace(strings.TrimPrefix(condition, "defined "))
+		if strings.HasPrefix(name, "env.") {
+			_, ok := b.env[strings.TrimPrefix(name, "env.")]
+			return ok, nil
+		}
+		_, ok := b.vars[name]
+		if !ok {
+			_, ok = b.deferred[name]
+		}
+		return ok, nil
+	}
+
+	if strings.HasPrefix(condition, "exists ") {
+		path, err := b.expand(strings.TrimPrefix(condition, "exists "))
+		if err != nil {
+			return false, err
+		}
+		return exists(strings.TrimSpace(path)), nil
+	}
+
+	if matches := comparisonExp.FindStringSubmatch(condition); matches != nil {
+		left, err := b.expand(matches[1])
+		if err != nil {
+			return false, err
+		}
+		right, err := b.expand(matches[3])
+		if err != nil {
+			return false, err
+		}
+		if matches[2] == "==" {
+			return conditionValue(left) == conditionValue(right), nil
+		}
+		return conditionValue(left) != conditionValue(right), nil
+	}
+
+	return false, fmt.Errorf("invalid condition: %q", condition)
+}
+
+// conditionValue trims a compared value, and removes any surrounding quotes
+func conditionValue(value string) string {
+	value = strings.TrimSpace(value)
+	if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+		return unquoted
+	}
+	return value
+}
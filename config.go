@@ -3,13 +3,18 @@ package main
 import (
 	"flag"
 	"fmt"
+	"time"
 )
 
 type config struct {
 	verbose     bool
 	veryVerbose bool
 	dryRun      bool
+	strict      bool
+	clean       bool
 	watch       bool
+	quietPeriod time.Duration
+	run         string
 	baseDir     string
 	byggFil     string
 	target      string
@@ -20,7 +25,11 @@ func parseConfig(args []string) (cfg config, err error) {
 
 	fs.StringVar(&cfg.byggFil, "f", "byggfil", "Bygg file")
 	fs.BoolVar(&cfg.dryRun, "n", false, "Performs a dry run")
+	fs.BoolVar(&cfg.strict, "strict", false, "Fails on undefined variables and missing target files")
 	fs.BoolVar(&cfg.watch, "w", false, "Watch mode")
+	fs.StringVar(&cfg.run, "run", "", "Target to (re)start after each successful build in watch mode")
+	fs.DurationVar(&cfg.quietPeriod, "quiet", 200*time.Millisecond, "Watch mode quiet period")
+	fs.BoolVar(&cfg.clean, "clean", false, "Removes all generated outputs")
 	fs.BoolVar(&cfg.verbose, "v", false, "Verbose")
 	fs.BoolVar(&cfg.veryVerbose, "vv", false, "Very verbose")
 	fs.StringVar(&cfg.baseDir, "C", ".", "Base dir")
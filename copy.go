@@ -3,37 +3,134 @@ package main
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
 func (b *bygge) handleCopy(target string, cmd string, args ...string) error {
+	sources := []string{}
 	source := strings.TrimPrefix(cmd, "copy:")
 	source = strings.TrimSpace(source)
-	if len(source) == 0 {
-		if len(args) == 0 {
-			return fmt.Errorf("Nothing to copy")
+	if len(source) > 0 {
+		sources = append(sources, source)
+	}
+	var options copyOptions
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		switch arg {
+		case "-r":
+			options.recursive = true
+		case "-p":
+			options.preserveTimes = true
+		case "":
+		default:
+			sources = append(sources, arg)
 		}
-		source = strings.TrimSpace(args[0])
 	}
+	if len(sources) == 0 {
+		return fmt.Errorf("Nothing to copy")
+	}
+
+	// A single file or directory is copied to the target,
+	// anything else is copied into the target directory.
+	if len(sources) == 1 && !isGlob(sources[0]) {
+		return b.copyPath(sources[0], target, options)
+	}
+
+	if err := os.MkdirAll(target, 0771); err != nil {
+		return err
+	}
+	matches, err := expandGlobs(sources...)
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		dest := filepath.Join(target, filepath.Base(match))
+		if err := b.copyPath(match, dest, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type copyOptions struct {
+	recursive     bool
+	preserveTimes bool
+	// Copy even if the target is up to date
+	force bool
+}
+
+func (b *bygge) copyPath(source string, target string, options copyOptions) error {
 	stat, err := os.Stat(source)
 	if err != nil {
 		return fmt.Errorf("Failed to read source: %w", err)
 	}
-	if stat.IsDir() {
-		return fmt.Errorf("Source must be a file")
+	if !stat.IsDir() {
+		return b.copyFile(source, target, stat, options)
 	}
+	if !options.recursive {
+		return fmt.Errorf("%q is a directory and \"-r\" was not specified", source)
+	}
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(target, rel)
+		if info.IsDir() {
+			return os.MkdirAll(dest, 0771)
+		}
+		return b.copyFile(path, dest, info, options)
+	})
+}
+
+// copyFile copies a single file, unless the target is at least as new as the source
+// and the copy is not forced.
+// The file mode is always preserved, and the modification time if requested.
+// The copy is written to a temporary file that replaces the target when complete,
+// so that an interrupted copy never leaves a truncated target.
+func (b *bygge) copyFile(source string, target string, stat os.FileInfo, options copyOptions) error {
+	if targetStat, err := os.Stat(target); err == nil {
+		if targetStat.IsDir() {
+			return fmt.Errorf("Will not overwrite directory %q", target)
+		}
+		if !options.force && !stat.ModTime().After(targetStat.ModTime()) {
+			b.verbose("%s is up to date, skipping copy", target)
+			return nil
+		}
+	}
+	b.verbose("Copying %s to %s", source, target)
 	sourceStream, err := os.Open(source)
 	if err != nil {
 		return fmt.Errorf("Failed to open file: %w", err)
 	}
-	targetStream, err := os.Create(target)
+	defer sourceStream.Close()
+	targetStream, err := ioutil.TempFile(filepath.Dir(target), "."+filepath.Base(target)+".*")
 	if err != nil {
 		return fmt.Errorf("Failed to create file: %w", err)
 	}
-	_, err = io.Copy(targetStream, sourceStream)
-	if err != nil {
+	defer func() {
+		_ = targetStream.Close()
+		_ = os.Remove(targetStream.Name())
+	}()
+	if _, err = io.Copy(targetStream, sourceStream); err != nil {
 		return err
 	}
-	return nil
+	if err = targetStream.Chmod(stat.Mode().Perm()); err != nil {
+		return err
+	}
+	if err = targetStream.Close(); err != nil {
+		return err
+	}
+	if options.preserveTimes {
+		if err = os.Chtimes(targetStream.Name(), stat.ModTime(), stat.ModTime()); err != nil {
+			return err
+		}
+	}
+	return os.Rename(targetStream.Name(), target)
 }
@@ -4,21 +4,36 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
 
-func (b *bygge) handleDownload(target string, url string, checksum ...string) error {
-	if !(strings.HasSuffix(url, ".tar") || strings.HasSuffix(url, ".tar.gz") || strings.HasSuffix(url, "tgz")) {
+func (b *bygge) handleDownload(target string, url string, args ...string) error {
+	if strings.HasPrefix(url, "file://") {
+		return b.unpackFile(target, strings.TrimPrefix(url, "file://"), args...)
+	}
+
+	if !isArchive(url) {
 		return fmt.Errorf("Unsupported file: %v", url)
 	}
 
+	options, err := parseArchiveOptions(args)
+	if err != nil {
+		return err
+	}
+
 	b.verbose("Downloading %s", url)
 	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
 	if err != nil {
@@ -49,11 +64,7 @@ func (b *bygge) handleDownload(target string, url string, checksum ...string) er
 		}
 	}
 
-	if err = os.MkdirAll(target, 0771); err != nil {
-		return err
-	}
-
-	tmpFile, err := ioutil.TempFile(os.TempDir(), target)
+	tmpFile, err := ioutil.TempFile(os.TempDir(), filepath.Base(target))
 	if err != nil {
 		return err
 	}
@@ -67,50 +78,156 @@ func (b *bygge) handleDownload(target string, url string, checksum ...string) er
 	}
 	_, _ = tmpFile.Seek(0, 0)
 
-	if len(checksum) > 0 {
-		ok, err := validateChecksum(tmpFile, checksum[0])
+	if err = extractArchive(target, url, tmpFile, options); err != nil {
+		return err
+	}
+
+	if !modificationDate.IsZero() {
+		_ = os.Chtimes(target, modificationDate, modificationDate)
+	}
+
+	return nil
+}
+
+func (b *bygge) handleUnpack(target string, cmd string, args ...string) error {
+	archive := strings.TrimPrefix(cmd, "unpack:")
+	archive = strings.TrimSpace(archive)
+	if len(archive) == 0 {
+		if len(args) == 0 {
+			return fmt.Errorf("Nothing to unpack")
+		}
+		archive = strings.TrimSpace(args[0])
+		args = args[1:]
+	}
+	return b.unpackFile(target, archive, args...)
+}
+
+// unpackFile unpacks a local archive into the target directory.
+// Like downloads, the target is considered up to date if it is not older
+// than the archive.
+func (b *bygge) unpackFile(target string, archive string, args ...string) error {
+	if !isArchive(archive) {
+		return fmt.Errorf("Unsupported file: %v", archive)
+	}
+
+	options, err := parseArchiveOptions(args)
+	if err != nil {
+		return err
+	}
+
+	stat, err := os.Stat(archive)
+	if err != nil {
+		return fmt.Errorf("Failed to read archive: %w", err)
+	}
+	if !getFileDate(target).Before(stat.ModTime()) {
+		b.verbose("%s unmodified, skipping unpack", archive)
+		return nil
+	}
+
+	b.verbose("Unpacking %s", archive)
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = extractArchive(target, archive, file, options); err != nil {
+		return err
+	}
+
+	modificationDate := stat.ModTime()
+	_ = os.Chtimes(target, modificationDate, modificationDate)
+
+	return nil
+}
+
+type archiveOptions struct {
+	checksum string
+	strip    int
+}
+
+// parseArchiveOptions parses the optional checksum and "strip:<n>"
+// arguments of download and unpack commands.
+func parseArchiveOptions(args []string) (archiveOptions, error) {
+	var options archiveOptions
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "strip:") {
+			strip, err := strconv.Atoi(strings.TrimPrefix(arg, "strip:"))
+			if err != nil || strip < 0 {
+				return options, fmt.Errorf("invalid strip option %q", arg)
+			}
+			options.strip = strip
+		} else {
+			options.checksum = arg
+		}
+	}
+	return options, nil
+}
+
+func isArchive(name string) bool {
+	return strings.HasSuffix(name, ".tar") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, "tgz")
+}
+
+func extractArchive(target string, name string, file *os.File, options archiveOptions) error {
+	if options.checksum != "" {
+		ok, err := validateChecksum(file, options.checksum)
 		if err != nil {
 			return err
 		}
 		if !ok {
-			return fmt.Errorf("checksum verification failed for %q", url)
+			return fmt.Errorf("checksum verification failed for %q", name)
 		}
-		_, _ = tmpFile.Seek(0, 0)
+		_, _ = file.Seek(0, 0)
 	}
 
-	var reader io.Reader = tmpFile
-	if strings.HasSuffix(url, "gz") {
+	var reader io.Reader = file
+	if strings.HasSuffix(name, "gz") {
+		var err error
 		if reader, err = gzip.NewReader(reader); err != nil {
 			return err
 		}
 	}
 
-	err = unpackArchive(target, reader)
-	if err != nil {
+	if err := os.MkdirAll(target, 0771); err != nil {
 		return err
 	}
 
-	if !modificationDate.IsZero() {
-		_ = os.Chtimes(target, modificationDate, modificationDate)
-	}
+	return unpackArchive(target, reader, options.strip)
+}
 
-	return nil
+// digests maps supported checksum prefixes to hash implementations
+var digests = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
 }
 
 func validateChecksum(file *os.File, checksum string) (bool, error) {
-	if !strings.HasPrefix(checksum, "md5:") {
-		return false, fmt.Errorf("checksum must start with \"md5:\"")
+	parts := strings.SplitN(checksum, ":", 2)
+	newHash, ok := digests[parts[0]]
+	if len(parts) != 2 || !ok {
+		return false, fmt.Errorf("checksum must start with \"md5:\", \"sha1:\", \"sha256:\" or \"sha512:\"")
 	}
 
-	hash := md5.New()
-	if _, err := io.Copy(hash, file); err != nil {
+	sum, err := computeDigest(file, newHash)
+	if err != nil {
 		return false, err
 	}
-	sum := fmt.Sprintf("md5:%x", hash.Sum(nil))
-	return sum == checksum, nil
+	return strings.EqualFold(sum, parts[1]), nil
 }
 
-func unpackArchive(target string, source io.Reader) error {
+func computeDigest(source io.Reader, newHash func() hash.Hash) (string, error) {
+	hash := newHash()
+	if _, err := io.Copy(hash, source); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// unpackArchive extracts a tar stream into the target directory, removing
+// the given number of leading path components from each entry.
+func unpackArchive(target string, source io.Reader, strip int) error {
 	tarReader := tar.NewReader(source)
 
 	for {
@@ -118,20 +235,35 @@ func unpackArchive(target string, source io.Reader) error {
 		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return err
+		}
 		finfo := hdr.FileInfo()
 
+		cleaned := strings.Trim(path.Clean(hdr.Name), "/")
+		if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			return fmt.Errorf("Will not unpack %q, it is outside of the target directory", hdr.Name)
+		}
+		parts := strings.Split(cleaned, "/")
+		if len(parts) <= strip {
+			continue
+		}
+		name := path.Join(parts[strip:]...)
+
 		switch {
 		case finfo.IsDir():
-			dir := path.Join(target, hdr.Name)
+			dir := path.Join(target, name)
 			if err = os.MkdirAll(dir, finfo.Mode()); err != nil {
 				return err
 			}
 		case finfo.Mode().IsRegular():
-			dest, err := os.Create(path.Join(target, hdr.Name))
+			dest, err := os.Create(path.Join(target, name))
 			if err != nil {
 				return err
 			}
-			if _, err = io.Copy(dest, tarReader); err != nil {
+			_, err = io.Copy(dest, tarReader)
+			dest.Close()
+			if err != nil {
 				return err
 			}
 		default:
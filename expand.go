@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// expander expands variable references, using the given variables and environment.
+//
+// Supported forms are $VAR, ${VAR}, ${env.VAR}, ${VAR:-default} and ${VAR:?message},
+// and function calls like $(addprefix build/,$OBJS).
+// In strict mode, references to undefined variables are errors.
+type expander struct {
+	vars     map[string]string
+	env      map[string]string
+	deferred map[string]string
+	strict   bool
+	glob     func(patterns ...string) []string
+
+	// Expander used for the values of deferred variables, if not this one
+	late *expander
+	// Deferred variables currently being expanded, to detect cycles
+	expanding map[string]bool
+}
+
+func (b *bygge) newExpander(vars, env, deferred map[string]string) expander {
+	return expander{
+		vars:     vars,
+		env:      env,
+		deferred: deferred,
+		strict:   b.cfg.strict,
+		glob: func(patterns ...string) []string {
+			return b.glob(false, patterns...)
+		},
+		expanding: map[string]bool{},
+	}
+}
+
+func (b *bygge) expand(expr string) (string, error) {
+	return b.newExpander(b.vars, b.env, b.deferred).expand(expr)
+}
+
+func (e expander) expand(expr string) (string, error) {
+	var result strings.Builder
+	start := 0
+	for i := 0; i+1 < len(expr); i++ {
+		if expr[i] != '$' {
+			continue
+		}
+		switch expr[i+1] {
+		case '{':
+			// Function calls in default values are handled by lookup
+			if end := strings.IndexByte(expr[i:], '}'); end >= 0 {
+				i += end
+			}
+		case '(':
+			if !isFunctionCall(expr[i+2:]) {
+				continue
+			}
+			end := matchingParen(expr, i+1)
+			if end < 0 {
+				return "", fmt.Errorf("unterminated function call %q", expr[i:])
+			}
+			expanded, err := e.expandVariables(expr[start:i])
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(expanded)
+			value, err := e.call(expr[i+2 : end])
+			if err != nil {
+				return "", err
+			}
+			result.WriteString(value)
+			start = end + 1
+			i = end
+		}
+	}
+	expanded, err := e.expandVariables(expr[start:])
+	if err != nil {
+		return "", err
+	}
+	result.WriteString(expanded)
+	return result.String(), nil
+}
+
+func (e expander) expandVariables(expr string) (string, error) {
+	var err error
+	result := os.Expand(expr, func(varExpr string) string {
+		value, lookupErr := e.lookup(varExpr)
+		if lookupErr != nil && err == nil {
+			err = lookupErr
+		}
+		return value
+	})
+	return result, err
+}
+
+func (e expander) lookup(varExpr string) (string, error) {
+	varExpr = strings.Trim(varExpr, " \t")
+
+	name := varExpr
+	operator := ""
+	word := ""
+	if i := strings.Index(varExpr, ":"); i >= 0 && i+1 < len(varExpr) {
+		if next := varExpr[i+1]; next == '-' || next == '?' {
+			name = strings.TrimSpace(varExpr[:i])
+			operator = varExpr[i : i+2]
+			word = varExpr[i+2:]
+		}
+	}
+
+	value, defined := e.value(name)
+	if raw, ok := e.deferred[name]; ok && !defined {
+		var err error
+		if value, err = e.expandDeferred(name, raw); err != nil {
+			return "", err
+		}
+		defined = true
+	}
+
+	switch operator {
+	case ":-":
+		if value == "" {
+			return e.expand(word)
+		}
+	case ":?":
+		if value == "" {
+			message, err := e.expand(word)
+			if err != nil {
+				return "", err
+			}
+			message = strings.TrimSpace(message)
+			if message == "" {
+				message = fmt.Sprintf("%q is not set", name)
+			}
+			return "", errors.New(message)
+		}
+	default:
+		if !defined && e.strict {
+			return "", fmt.Errorf("undefined variable %q", name)
+		}
+	}
+
+	return value, nil
+}
+
+func (e expander) value(name string) (string, bool) {
+	if strings.Contains(name, ".") {
+		parts := strings.SplitN(name, ".", 2)
+		context := parts[0]
+		name := parts[1]
+
+		if context == "env" {
+			value, ok := e.env[name]
+			return value, ok
+		}
+		return "", false
+	}
+	value, ok := e.vars[name]
+	return value, ok
+}
+
+func (e expander) expandDeferred(name, raw string) (string, error) {
+	late := e
+	if e.late != nil {
+		late = *e.late
+	}
+	if late.expanding[name] {
+		return "", fmt.Errorf("recursive variable %q", name)
+	}
+	late.expanding[name] = true
+	defer delete(late.expanding, name)
+	return late.expand(raw)
+}
+
+// matchingParen returns the index of the parenthesis closing the one at open,
+// or -1 if there is none.
+func matchingParen(expr string, open int) int {
+	depth := 0
+	for i := open; i < len(expr); i++ {
+		switch expr[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// function is a built-in function, callable as $(name arg1,arg2,...).
+// Arguments are separated by commas, and the last argument gets any remaining
+// commas. Lists are separated by whitespace.
+type function struct {
+	arguments int
+	call      func(e expander, args []string) (string, error)
+}
+
+var functions = map[string]function{
+	"subst": {3, func(e expander, args []string) (string, error) {
+		return strings.ReplaceAll(args[2], args[0], args[1]), nil
+	}},
+	"patsubst": {3, func(e expander, args []string) (string, error) {
+		pattern := strings.TrimSpace(args[0])
+		replacement := strings.TrimSpace(args[1])
+		return mapWords(args[2], func(word string) string {
+			if stem, ok := matchPattern(pattern, word); ok {
+				return strings.Replace(replacement, "%", stem, 1)
+			}
+			return word
+		}), nil
+	}},
+	"basename": {1, func(e expander, args []string) (string, error) {
+		return mapWords(args[0], func(word string) string {
+			return strings.TrimSuffix(word, filepath.Ext(word))
+		}), nil
+	}},
+	"dir": {1, func(e expander, args []string) (string, error) {
+		return mapWords(args[0], func(word string) string {
+			if slash := strings.LastIndex(word, "/"); slash >= 0 {
+				return word[:slash+1]
+			}
+			return "./"
+		}), nil
+	}},
+	"notdir": {1, func(e expander, args []string) (string, error) {
+		return mapWords(args[0], func(word string) string {
+			return word[strings.LastIndex(word, "/")+1:]
+		}), nil
+	}},
+	"addprefix": {2, func(e expander, args []string) (string, error) {
+		prefix := strings.TrimSpace(args[0])
+		return mapWords(args[1], func(word string) string {
+			return prefix + word
+		}), nil
+	}},
+	"addsuffix": {2, func(e expander, args []string) (string, error) {
+		suffix := strings.TrimSpace(args[0])
+		return mapWords(args[1], func(word string) string {
+			return word + suffix
+		}), nil
+	}},
+	"wildcard": {1, func(e expander, args []string) (string, error) {
+		matches := e.glob(strings.Fields(args[0])...)
+		return strings.Join(matches, " "), nil
+	}},
+	"filter": {2, func(e expander, args []string) (string, error) {
+		patterns := strings.Fields(args[0])
+		result := []string{}
+		for _, word := range strings.Fields(args[1]) {
+			for _, pattern := range patterns {
+				if _, ok := matchPattern(pattern, word); ok {
+					result = append(result, word)
+					break
+				}
+			}
+		}
+		return strings.Join(result, " "), nil
+	}},
+	"sort": {1, func(e expander, args []string) (string, error) {
+		words := strings.Fields(args[0])
+		sort.Strings(words)
+		result := []string{}
+		for i, word := range words {
+			if i == 0 || word != words[i-1] {
+				result = append(result, word)
+			}
+		}
+		return strings.Join(result, " "), nil
+	}},
+}
+
+// call runs a function call, given the text between the parentheses.
+func (e expander) call(text string) (string, error) {
+	text = strings.TrimLeft(text, " \t")
+	name := functionName(text)
+	rest := strings.TrimLeft(text[len(name):], " \t")
+
+	fn, ok := functions[name]
+	if !ok {
+		return "", fmt.Errorf("unknown function %q", name)
+	}
+
+	args := splitArguments(rest, fn.arguments)
+	if len(args) != fn.arguments {
+		return "", fmt.Errorf("function %q expects %d arguments, got %d", name, fn.arguments, len(args))
+	}
+	for i, arg := range args {
+		expanded, err := e.expand(arg)
+		if err != nil {
+			return "", err
+		}
+		args[i] = expanded
+	}
+
+	return fn.call(e, args)
+}
+
+// functionName returns the name of the function called, given the text following "$(".
+func functionName(text string) string {
+	text = strings.TrimLeft(text, " \t")
+	if end := strings.IndexAny(text, " \t)"); end >= 0 {
+		return text[:end]
+	}
+	return text
+}
+
+// isFunctionCall checks if the text following "$(" calls a built-in function.
+// Other uses, like shell command substitution, are left as is.
+func isFunctionCall(text string) bool {
+	_, ok := functions[functionName(text)]
+	return ok
+}
+
+// splitArguments splits function arguments on commas outside of
+// nested function calls, into at most n parts.
+func splitArguments(text string, n int) []string {
+	args := []string{}
+	depth := 0
+	start := 0
+	for i := 0; i < len(text) && len(args) < n-1; i++ {
+		switch text[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, text[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(args, text[start:])
+}
+
+// matchPattern matches a word against a pattern, where "%" matches any string.
+// Returns the string matched by "%".
+func matchPattern(pattern, word string) (string, bool) {
+	percent := strings.Index(pattern, "%")
+	if percent < 0 {
+		return "", pattern == word
+	}
+	prefix := pattern[:percent]
+	suffix := pattern[percent+1:]
+	if len(word) < len(prefix)+len(suffix) || !strings.HasPrefix(word, prefix) || !strings.HasSuffix(word, suffix) {
+		return "", false
+	}
+	return word[len(prefix) : len(word)-len(suffix)], true
+}
+
+func mapWords(list string, mapper func(string) string) string {
+	words := strings.Fields(list)
+	for i, word := range words {
+		words[i] = mapper(word)
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func (b *bygge) handleGit(target string, cmd string, args ...string) error {
+	repo := strings.TrimPrefix(cmd, "git:")
+	repo = strings.TrimSpace(repo)
+	if len(repo) == 0 {
+		if len(args) == 0 {
+			return fmt.Errorf("No repository specified")
+		}
+		repo = strings.TrimSpace(args[0])
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("No ref specified for repository %q", repo)
+	}
+	ref := args[0]
+	expected := ""
+	if len(args) > 1 {
+		expected = strings.ToLower(args[1])
+	}
+
+	cloned := false
+	if !exists(target) {
+		b.verbose("Cloning %s into %s", repo, target)
+		if _, err := b.runGit("", "clone", "-q", "--no-checkout", repo, target); err != nil {
+			return err
+		}
+		cloned = true
+	} else if !exists(filepath.Join(target, ".git")) {
+		return fmt.Errorf("Will not overwrite non-repository target %q", target)
+	}
+
+	head, _ := b.runGit(target, "rev-parse", "-q", "--verify", "HEAD")
+	commit, err := b.resolveGitRef(target, ref)
+
+	if cloned || err != nil || commit != head {
+		if !cloned {
+			b.verbose("Fetching %s", repo)
+			if _, err = b.runGit(target, "fetch", "-q", "--tags", "--force", "origin"); err != nil {
+				return err
+			}
+			if commit, err = b.resolveGitRef(target, ref); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		if expected != "" && !strings.HasPrefix(commit, expected) {
+			return fmt.Errorf("commit verification failed for %q, %s resolves to %s", repo, ref, commit)
+		}
+		b.verbose("Checking out %s (%s) in %s", ref, commit, target)
+		if _, err = b.runGit(target, "checkout", "-q", "-f", "--detach", commit); err != nil {
+			return err
+		}
+		if head, err = b.runGit(target, "rev-parse", "-q", "--verify", "HEAD"); err != nil {
+			return err
+		}
+		// Checking out does not touch the directory itself, make sure
+		// that dependents see the target as updated.
+		now := time.Now()
+		if err = os.Chtimes(target, now, now); err != nil {
+			return err
+		}
+	} else {
+		b.verbose("%s is already at %s, skipping checkout", target, ref)
+	}
+
+	if head != commit || (expected != "" && !strings.HasPrefix(head, expected)) {
+		return fmt.Errorf("commit verification failed for %q, checked out %s", repo, head)
+	}
+
+	return nil
+}
+
+// runsGit checks if a target has "git:" build commands. These targets are built
+// every time, to make sure that the checked out commit matches the requested ref.
+func runsGit(t target) bool {
+	for _, cmd := range t.buildCommands {
+		if strings.HasPrefix(cmd.command, "git:") {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveGitRef finds the commit hash for a tag, branch or commit, preferring
+// remote branches over stale local ones.
+func (b *bygge) resolveGitRef(dir string, ref string) (string, error) {
+	for _, candidate := range []string{"origin/" + ref, ref} {
+		commit, err := b.runGit(dir, "rev-parse", "-q", "--verify", candidate+"^{commit}")
+		if err == nil {
+			return commit, nil
+		}
+	}
+	return "", fmt.Errorf("unknown git ref %q", ref)
+}
+
+func (b *bygge) runGit(dir string, args ...string) (string, error) {
+	if dir != "" {
+		args = append([]string{"-C", dir}, args...)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Env = b.envList()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %v failed: %w: %s", args, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
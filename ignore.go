@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const ignoreFile = ".byggignore"
+
+// ignorePatterns holds a subset of gitignore style patterns.
+// Patterns containing a slash are matched against the leading
+// part of paths relative to the base dir, others against each
+// path component.
+type ignorePatterns []string
+
+func loadIgnorePatterns(file string) (ignorePatterns, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	patterns := ignorePatterns{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+func (patterns ignorePatterns) matches(file string) bool {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(file)), "/")
+	for _, pattern := range patterns {
+		anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+		pattern = strings.Trim(pattern, "/")
+		if anchored {
+			patternParts := strings.Split(pattern, "/")
+			if len(parts) < len(patternParts) {
+				continue
+			}
+			leading := strings.Join(parts[:len(patternParts)], "/")
+			if ok, _ := path.Match(pattern, leading); ok {
+				return true
+			}
+		} else {
+			for _, part := range parts {
+				if part == "." || part == ".." {
+					continue
+				}
+				if ok, _ := path.Match(pattern, part); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (patterns ignorePatterns) filter(files []string) []string {
+	if len(patterns) == 0 {
+		return files
+	}
+	result := []string{}
+	for _, file := range files {
+		if !patterns.matches(file) {
+			result = append(result, file)
+		}
+	}
+	return result
+}
+
+// globFiles returns the files matching a glob pattern, ignoring errors.
+func globFiles(pattern string) []string {
+	matches, _ := filepath.Glob(pattern)
+	return matches
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// handleImport loads a child bygg file and merges its targets into the
+// build graph, prefixed by the given alias. Imported targets keep
+// their paths relative to the directory of the child bygg file.
+func (b *bygge) handleImport(file string, alias string) error {
+	file = filepath.Clean(file)
+	alias = filepath.Clean(alias)
+	dir := filepath.Dir(file)
+
+	importing := map[string]bool{}
+	for path := range b.importing {
+		importing[path] = true
+	}
+	self, err := filepath.Abs(b.cfg.byggFil)
+	if err != nil {
+		return err
+	}
+	importing[self] = true
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	if importing[abs] {
+		return fmt.Errorf("cyclic import of %q", file)
+	}
+
+	cfg := b.cfg
+	cfg.baseDir = dir
+	cfg.byggFil = filepath.Base(file)
+	child, err := newBygge(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to import %q: %w", file, err)
+	}
+	child.output = b.output
+	child.importing = importing
+
+	pwd, _ := os.Getwd()
+	if err = os.Chdir(dir); err != nil {
+		return err
+	}
+	err = child.loadScript()
+	os.Chdir(pwd)
+	if err != nil {
+		return fmt.Errorf("failed to import %q: %w", file, err)
+	}
+
+	for input := range child.scriptInputs {
+		b.scriptInputs[filepath.Join(dir, input)] = true
+	}
+	for pattern := range child.globs {
+		pattern = filepath.Join(dir, pattern)
+		b.globs[pattern] = strings.Join(b.ignores.filter(globFiles(pattern)), " ")
+	}
+
+	for name, t := range child.targets {
+		local := name
+		if t.dir != "" {
+			local = t.local
+		}
+		env := t.env
+		if env == nil {
+			env = child.env
+		}
+
+		imported := b.targets[filepath.Join(alias, name)]
+		imported.name = filepath.Join(alias, name)
+		imported.dir = filepath.Join(dir, t.dir)
+		imported.local = local
+		imported.env = env
+		imported.force = imported.force || t.force
+		imported.phony = imported.phony || t.phony
+		imported.output = imported.output || t.output
+		imported.buildCommands = append(imported.buildCommands, t.buildCommands...)
+		imported.assignments = append(imported.assignments, t.assignments...)
+		if len(imported.outputs) == 0 {
+			for _, output := range t.outputs {
+				imported.outputs = append(imported.outputs, filepath.Join(alias, output))
+			}
+		}
+
+		importDependency := func(dep string) string {
+			if _, ok := child.targets[dep]; ok {
+				return filepath.Join(alias, dep)
+			} else if !filepath.IsAbs(dep) {
+				return filepath.Join(dir, dep)
+			}
+			return dep
+		}
+		for _, dep := range t.dependencies {
+			imported.dependencies = append(imported.dependencies, importDependency(dep))
+		}
+		for _, dep := range t.orderOnly {
+			imported.orderOnly = append(imported.orderOnly, importDependency(dep))
+		}
+
+		b.targets[imported.name] = imported
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+func (b *bygge) handleMove(target string, cmd string, args ...string) error {
+	source := strings.TrimPrefix(cmd, "move:")
+	source = strings.TrimSpace(source)
+	if len(source) == 0 {
+		if len(args) == 0 {
+			return fmt.Errorf("Nothing to move")
+		}
+		source = strings.TrimSpace(args[0])
+	}
+	if _, err := os.Stat(source); err != nil {
+		return fmt.Errorf("Failed to read source: %w", err)
+	}
+	if stat, err := os.Stat(target); err == nil && stat.IsDir() {
+		return fmt.Errorf("Will not overwrite directory %q", target)
+	}
+	err := os.Rename(source, target)
+	if err == nil || !isCrossDeviceError(err) {
+		return err
+	}
+	// Renaming fails across file systems, fall back to copy and remove
+	b.verbose("Failed to rename %s, copying instead", source)
+	options := copyOptions{
+		recursive:     true,
+		preserveTimes: true,
+		force:         true,
+	}
+	if err := b.copyPath(source, target, options); err != nil {
+		return err
+	}
+	return os.RemoveAll(source)
+}
+
+// isCrossDeviceError checks if renaming failed because the source and
+// target are on different file systems.
+func isCrossDeviceError(err error) bool {
+	if errors.Is(err, syscall.EXDEV) {
+		return true
+	}
+	// ERROR_NOT_SAME_DEVICE
+	return runtime.GOOS == "windows" && errors.Is(err, syscall.Errno(17))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// stopTimeout is how long a running command gets to exit after being
+// interrupted, before it is killed.
+const stopTimeout = 5 * time.Second
+
+type runningCommand struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+}
+
+// resolveRunTarget builds the dependencies of the target given by the "-run" option.
+func (b *bygge) resolveRunTarget() error {
+	t, ok := b.targets[b.cfg.run]
+	if !ok {
+		return fmt.Errorf("no such run target %q", b.cfg.run)
+	}
+	for _, dep := range t.dependencies {
+		if err := b.resolveDependency(t, dep, t.assignments); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restartRunCommand stops the running command, if any, and starts the build
+// command of the target given by the "-run" option. The dependencies of the
+// run target are expected to be built by resolveRunTarget.
+func (b *bygge) restartRunCommand() error {
+	t, ok := b.targets[b.cfg.run]
+	if !ok {
+		return fmt.Errorf("no such run target %q", b.cfg.run)
+	}
+	if len(t.buildCommands) != 1 {
+		return fmt.Errorf("run target %q must have a single build command", t.name)
+	}
+
+	runEnv, err := b.targetEnv(t, t.assignments)
+	if err != nil {
+		return err
+	}
+	command, err := b.expandCommand(t.buildCommands[0], t.assignments, runEnv)
+	if err != nil {
+		return err
+	}
+	parts, err := splitQuoted(command)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("empty run command")
+	}
+
+	b.stopRunCommand()
+
+	b.verbose("Starting %q with args %v", parts[0], parts[1:])
+	cmd := exec.Command(parts[0], parts[1:]...)
+	cmd.Dir = t.dir
+	cmd.Env = envList(runEnv)
+	cmd.Stderr = b.output
+	cmd.Stdout = b.output
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(done)
+	}()
+	b.running = &runningCommand{
+		cmd:  cmd,
+		done: done,
+	}
+	return nil
+}
+
+// stopRunCommand interrupts the running command and waits for it to exit.
+func (b *bygge) stopRunCommand() {
+	if b.running == nil {
+		return
+	}
+	running := b.running
+	b.running = nil
+
+	select {
+	case <-running.done:
+		return
+	default:
+	}
+
+	b.verbose("Stopping %q", running.cmd.Path)
+	if err := running.cmd.Process.Signal(os.Interrupt); err != nil {
+		_ = running.cmd.Process.Kill()
+	}
+	select {
+	case <-running.done:
+	case <-time.After(stopTimeout):
+		b.verbose("%q did not stop, killing", running.cmd.Path)
+		_ = running.cmd.Process.Kill()
+		<-running.done
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func (b *bygge) handleSymlink(target string, cmd string, args ...string) error {
+	source := strings.TrimPrefix(cmd, "symlink:")
+	source = strings.TrimSpace(source)
+	if len(source) == 0 {
+		if len(args) == 0 {
+			return fmt.Errorf("Nothing to link to")
+		}
+		source = strings.TrimSpace(args[0])
+	}
+	stat, err := os.Lstat(target)
+	if err == nil {
+		if stat.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("Will not overwrite non-link target %q", target)
+		}
+		if current, err := os.Readlink(target); err == nil && current == source {
+			return nil
+		}
+		if err = os.Remove(target); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(source, target)
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+func (b *bygge) handleTouch(target string, cmd string, args ...string) error {
+	path := strings.TrimPrefix(cmd, "touch:")
+	path = strings.TrimSpace(path)
+	if len(path) == 0 {
+		path = target
+		if len(args) > 0 {
+			path = strings.TrimSpace(args[0])
+		}
+	}
+	now := time.Now()
+	if exists(path) {
+		return os.Chtimes(path, now, now)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
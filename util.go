@@ -81,3 +81,45 @@ func cleanPaths(paths ...string) []string {
 	}
 	return result
 }
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+// expandGlobs expands glob patterns, keeping other paths as is.
+// Patterns that match nothing are reported as errors.
+func expandGlobs(patterns ...string) ([]string, error) {
+	result := []string{}
+	for _, pattern := range patterns {
+		if !isGlob(pattern) {
+			result = append(result, pattern)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("No files matching %q", pattern)
+		}
+		result = append(result, matches...)
+	}
+	return result, nil
+}
+
+func copyMap(source map[string]string) map[string]string {
+	result := make(map[string]string, len(source))
+	for k, v := range source {
+		result[k] = v
+	}
+	return result
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
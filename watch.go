@@ -1,10 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
+// errWatchUnsupported is returned by waitForEvents when event based
+// watching is not available, causing a fallback to polling.
+var errWatchUnsupported = errors.New("event based watching not supported")
+
 func (b *bygge) unresolve() {
 	for k, tgt := range b.targets {
 		tgt.resolved = false
@@ -12,20 +21,73 @@ func (b *bygge) unresolve() {
 	}
 }
 
-func (b *bygge) waitForChange(tgt target) error {
+// waitForChange waits until the target tree or build script changes, and
+// then until there have been no more changes for the configured quiet period,
+// so that changes to several files are handled together.
+// Returns the changed files.
+func (b *bygge) waitForChange(tgt target) ([]string, error) {
+	before := b.snapshot(tgt)
+
+	err := b.waitForEvents(tgt)
+	if err == errWatchUnsupported {
+		err = b.pollForChange(tgt)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	after := b.snapshot(tgt)
+	for b.cfg.quietPeriod > 0 {
+		time.Sleep(b.cfg.quietPeriod)
+		latest := b.snapshot(tgt)
+		if len(changedFiles(after, latest)) == 0 {
+			break
+		}
+		after = latest
+	}
+
+	return changedFiles(before, after), nil
+}
+
+func (b *bygge) pollForChange(tgt target) error {
 	start := time.Now()
 	for {
-		lastUpdate, err := b.lastUpdated(tgt)
+		changed, err := b.changedSince(tgt, start)
 		if err != nil {
 			return err
 		}
-		if lastUpdate.After(start) {
+		if changed {
 			return nil
 		}
 		time.Sleep(time.Millisecond * 500)
 	}
 }
 
+// changedSince checks if the target tree or the build script has changed since the given time.
+func (b *bygge) changedSince(tgt target, start time.Time) (bool, error) {
+	lastUpdate, err := b.lastUpdated(tgt)
+	if err != nil {
+		return false, err
+	}
+	return lastUpdate.After(start) || b.scriptChangedSince(start), nil
+}
+
+// scriptChangedSince checks if the bygg file has been updated since the given time,
+// or if the files matched by the "glob" template functions have changed.
+func (b *bygge) scriptChangedSince(start time.Time) bool {
+	for input := range b.scriptInputs {
+		if getFileDate(input).After(start) {
+			return true
+		}
+	}
+	for pattern, matches := range b.globs {
+		if strings.Join(b.ignores.filter(globFiles(pattern)), " ") != matches {
+			return true
+		}
+	}
+	return false
+}
+
 func (b *bygge) lastUpdated(tgt target) (time.Time, error) {
 
 	if b.visited[tgt.name] {
@@ -36,14 +98,17 @@ func (b *bygge) lastUpdated(tgt target) (time.Time, error) {
 		b.visited[tgt.name] = false
 	}()
 
-	mostRecentUpdate := getFileDate(tgt.name)
+	mostRecentUpdate := b.dependencyDate(tgt.file())
 
 	for _, depName := range tgt.dependencies {
 		modified := time.Time{}
 
 		dep, ok := b.targets[depName]
 		if !ok {
-			modified = getFileDate(depName)
+			if b.ignores.matches(depName) {
+				continue
+			}
+			modified = b.dependencyDate(depName)
 		} else {
 			var err error
 			modified, err = b.lastUpdated(dep)
@@ -58,3 +123,117 @@ func (b *bygge) lastUpdated(tgt target) (time.Time, error) {
 
 	return mostRecentUpdate, nil
 }
+
+// watchedFiles lists the build script inputs, the target and all of its dependencies.
+func (b *bygge) watchedFiles(tgt target) map[string]bool {
+	files := map[string]bool{}
+	for input := range b.scriptInputs {
+		files[input] = true
+	}
+	for pattern := range b.globs {
+		for _, match := range globFiles(pattern) {
+			files[match] = true
+		}
+		if dir := filepath.Dir(pattern); !isGlob(dir) {
+			files[dir] = true
+		}
+	}
+	b.addTargetFiles(tgt, files)
+	for file := range files {
+		if b.ignores.matches(file) {
+			delete(files, file)
+		}
+	}
+	return files
+}
+
+func (b *bygge) addTargetFiles(tgt target, files map[string]bool) {
+	if b.visited[tgt.name] {
+		return
+	}
+	b.visited[tgt.name] = true
+	defer func() {
+		b.visited[tgt.name] = false
+	}()
+
+	for _, file := range b.outputFiles(tgt) {
+		files[file] = true
+	}
+
+	for _, depName := range tgt.dependencies {
+		if dep, ok := b.targets[depName]; ok {
+			b.addTargetFiles(dep, files)
+		} else {
+			files[depName] = true
+		}
+	}
+}
+
+// watchedDirs lists the directories that need to be watched to detect
+// changes to any of the watched files. Directories that do not exist
+// are replaced by their closest existing parent.
+func (b *bygge) watchedDirs(tgt target) map[string]bool {
+	dirs := map[string]bool{}
+	for file := range b.watchedFiles(tgt) {
+		if !exists(filepath.Dir(file)) {
+			dirs[existingParent(filepath.Dir(file))] = true
+			continue
+		}
+		if isTreeDependency(file) {
+			_ = filepath.Walk(filepath.Dir(file), func(path string, info os.FileInfo, err error) error {
+				if err != nil || !info.IsDir() {
+					return nil
+				}
+				if b.ignores.matches(path) {
+					return filepath.SkipDir
+				}
+				dirs[path] = true
+				return nil
+			})
+			continue
+		}
+		dirs[filepath.Dir(file)] = true
+		if stat, err := os.Stat(file); err == nil && stat.IsDir() {
+			dirs[file] = true
+		}
+	}
+	return dirs
+}
+
+// existingParent returns the closest existing parent directory of a path.
+func existingParent(path string) string {
+	for !exists(path) {
+		parent := filepath.Dir(path)
+		if parent == path {
+			break
+		}
+		path = parent
+	}
+	return path
+}
+
+// snapshot records the modification times of all watched files.
+func (b *bygge) snapshot(tgt target) map[string]time.Time {
+	dates := map[string]time.Time{}
+	for file := range b.watchedFiles(tgt) {
+		dates[file] = b.dependencyDate(file)
+	}
+	return dates
+}
+
+// changedFiles lists the files that differ between two snapshots.
+func changedFiles(before, after map[string]time.Time) []string {
+	changed := []string{}
+	for file, date := range after {
+		if previous, ok := before[file]; !ok || !previous.Equal(date) {
+			changed = append(changed, file)
+		}
+	}
+	for file := range before {
+		if _, ok := after[file]; !ok {
+			changed = append(changed, file)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
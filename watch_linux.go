@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+const inotifyMask = syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE | syscall.IN_CREATE |
+	syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// waitForEvents waits for changes using inotify. Since editors often replace
+// files instead of writing to them, the directories containing the watched files
+// are watched instead of the files themselves.
+func (b *bygge) waitForEvents(tgt target) error {
+	start := time.Now()
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+	if err != nil {
+		b.verbose("Failed to initialize inotify: %v", err)
+		return errWatchUnsupported
+	}
+	defer syscall.Close(fd)
+
+	// Watched directories that do not exist yet are replaced by their closest
+	// existing parent, so watches are added again after each event.
+	watched := map[string]bool{}
+	addWatches := func() {
+		for dir := range b.watchedDirs(tgt) {
+			if watched[dir] {
+				continue
+			}
+			if _, err := syscall.InotifyAddWatch(fd, dir, inotifyMask); err != nil {
+				b.verbose("Failed to watch %q: %v", dir, err)
+			} else {
+				watched[dir] = true
+			}
+		}
+	}
+
+	addWatches()
+	if len(watched) == 0 {
+		return errWatchUnsupported
+	}
+
+	buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		// Events are only used as triggers, the actual check is done using
+		// file dates, to ignore changes to unrelated files in watched directories.
+		changed, err := b.changedSince(tgt, start)
+		if err != nil {
+			return err
+		}
+		if changed {
+			return nil
+		}
+		if _, err := syscall.Read(fd, buffer); err != nil && err != syscall.EINTR {
+			return err
+		}
+		addWatches()
+	}
+}
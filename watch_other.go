@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+func (b *bygge) waitForEvents(tgt target) error {
+	return errWatchUnsupported
+}
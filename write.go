@@ -0,0 +1,16 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+)
+
+func (b *bygge) handleWrite(target string, cmd string, args ...string) error {
+	text := strings.TrimPrefix(cmd, "write:")
+	parts := append([]string{}, args...)
+	if len(text) > 0 {
+		parts = append([]string{text}, parts...)
+	}
+	content := strings.Join(parts, " ") + "\n"
+	return ioutil.WriteFile(target, []byte(content), 0666)
+}